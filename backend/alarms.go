@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/gob"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// --- Metric Alarm Annotations ---
+
+// alarmsCacheTTL is how long the account's alarm list is reused by default; alarm
+// states only change once per evaluation period anyway.
+const alarmsCacheTTL = time.Minute
+
+func init() {
+	gob.Register([]metricAlarm{})
+	gob.Register(map[string][]metricAlarm{})
+}
+
+// metricAlarm is a single-metric CloudWatch alarm, reduced to what is needed to
+// match it against a query and show it.
+type metricAlarm struct {
+	Name       string            `json:"name"`
+	State      string            `json:"state"` // OK, ALARM or INSUFFICIENT_DATA
+	Reason     string            `json:"reason,omitempty"`
+	Threshold  float64           `json:"threshold"`
+	Comparison string            `json:"comparison,omitempty"`
+	Statistic  string            `json:"statistic,omitempty"`
+	Namespace  string            `json:"-"`
+	MetricName string            `json:"-"`
+	Dimensions map[string]string `json:"-"`
+}
+
+// watches reports whether the alarm is on exactly this metric: same namespace,
+// name and dimension set.
+func (a metricAlarm) watches(metric *types.Metric) bool {
+	if a.Namespace != aws.ToString(metric.Namespace) || a.MetricName != aws.ToString(metric.MetricName) || len(a.Dimensions) != len(metric.Dimensions) {
+		return false
+	}
+	for _, dim := range metric.Dimensions {
+		if value, ok := a.Dimensions[aws.ToString(dim.Name)]; !ok || value != aws.ToString(dim.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// listMetricAlarms returns the account's single-metric alarms, cached for
+// CACHE_TTLS "alarms" (default a minute) so annotating every metrics response costs
+// at most one DescribeAlarms sweep per TTL. Alarms on metric math expressions have
+// no single metric to match and are left out.
+func listMetricAlarms(ctx context.Context) ([]metricAlarm, error) {
+	if cached, ok := responseCache.Get("alarms"); ok {
+		return cached.([]metricAlarm), nil
+	}
+
+	alarms := make([]metricAlarm, 0)
+	paginator := cloudwatch.NewDescribeAlarmsPaginator(cwClient, &cloudwatch.DescribeAlarmsInput{
+		AlarmTypes: []types.AlarmType{types.AlarmTypeMetricAlarm},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range page.MetricAlarms {
+			if a.MetricName == nil {
+				continue
+			}
+			alarm := metricAlarm{
+				Name:       aws.ToString(a.AlarmName),
+				State:      string(a.StateValue),
+				Reason:     aws.ToString(a.StateReason),
+				Threshold:  aws.ToFloat64(a.Threshold),
+				Comparison: string(a.ComparisonOperator),
+				Statistic:  string(a.Statistic),
+				Namespace:  aws.ToString(a.Namespace),
+				MetricName: aws.ToString(a.MetricName),
+				Dimensions: make(map[string]string, len(a.Dimensions)),
+			}
+			if alarm.Statistic == "" {
+				alarm.Statistic = aws.ToString(a.ExtendedStatistic)
+			}
+			for _, dim := range a.Dimensions {
+				alarm.Dimensions[aws.ToString(dim.Name)] = aws.ToString(dim.Value)
+			}
+			alarms = append(alarms, alarm)
+		}
+	}
+	responseCache.Set("alarms", alarms, cacheTTL("alarms", alarmsCacheTTL))
+	return alarms, nil
+}
+
+// addAlarmAnnotations adds "alarms" to a metrics result: for each query ID, the
+// alarms watching that exact metric and dimensions, with their current state, and
+// "alarms_firing" listing the IDs with an alarm in ALARM. Failing to read alarms
+// (e.g. no cloudwatch:DescribeAlarms permission) only leaves the annotation out.
+func addAlarmAnnotations(ctx context.Context, result map[string]interface{}, queries []types.MetricDataQuery) {
+	alarms, err := listMetricAlarms(ctx)
+	if err != nil {
+		log.Printf("Could not read CloudWatch alarms to annotate metrics: %v", err)
+		return
+	}
+
+	byID := make(map[string][]metricAlarm)
+	firing := make([]string, 0)
+	for _, q := range queries {
+		if q.MetricStat == nil || q.MetricStat.Metric == nil {
+			continue
+		}
+		id := aws.ToString(q.Id)
+		for _, alarm := range alarms {
+			if !alarm.watches(q.MetricStat.Metric) {
+				continue
+			}
+			byID[id] = append(byID[id], alarm)
+			if alarm.State == string(types.StateValueAlarm) && (len(firing) == 0 || firing[len(firing)-1] != id) {
+				firing = append(firing, id)
+			}
+		}
+	}
+	result["alarms"] = byID
+	result["alarms_firing"] = firing
+}
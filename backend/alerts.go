@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// --- Threshold Alerts ---
+
+// alertPayload is the JSON body posted to ALERT_WEBHOOK_URL when a metric reaches
+// its critical level.
+type alertPayload struct {
+	Metric    string  `json:"metric"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	Instance  string  `json:"instance"`
+	Timestamp string  `json:"timestamp"`
+}
+
+// alarmAlertPayload is the JSON body posted to ALERT_WEBHOOK_URL when a CloudWatch
+// alarm goes into or out of ALARM.
+type alarmAlertPayload struct {
+	Alarm         string  `json:"alarm"`
+	State         string  `json:"state"`
+	PreviousState string  `json:"previous_state,omitempty"`
+	Reason        string  `json:"reason,omitempty"`
+	Metric        string  `json:"metric"`
+	Threshold     float64 `json:"threshold"`
+	Timestamp     string  `json:"timestamp"`
+}
+
+// alertWebhook is where alerts are sent. Slack incoming webhooks only render a
+// "text" field, so they get a formatted message instead of the raw payload.
+type alertWebhook struct {
+	url   string
+	slack bool
+}
+
+// alertWebhookFromEnv returns the configured webhook, preferring SLACK_WEBHOOK_URL.
+// ok is false when alerting is not configured.
+func alertWebhookFromEnv() (alertWebhook, bool) {
+	if url := os.Getenv("SLACK_WEBHOOK_URL"); url != "" {
+		return alertWebhook{url: url, slack: true}, true
+	}
+	if url := os.Getenv("ALERT_WEBHOOK_URL"); url != "" {
+		return alertWebhook{url: url}, true
+	}
+	return alertWebhook{}, false
+}
+
+// send posts payload to the webhook, or text when it is a Slack webhook.
+func (hook alertWebhook) send(ctx context.Context, payload interface{}, text string) error {
+	body := payload
+	if hook.slack {
+		body = map[string]string{"text": ":rotating_light: CloudPulse: " + text}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// alerter tracks what has been alerted on between checks.
+type alerter struct {
+	hook        alertWebhook
+	cooldown    time.Duration
+	lastFired   map[string]time.Time // metric ID -> last alert
+	alarmStates map[string]string    // alarm name -> state at the last check
+}
+
+// startAlerter checks the latest EC2 metrics and the account's CloudWatch alarms
+// every interval. It posts to hook when a metric reaches its metricThresholds
+// critical level, at most once per cooldown for each metric, and when an alarm goes
+// into or out of ALARM. It stops when ctx is cancelled.
+func startAlerter(ctx context.Context, hook alertWebhook, interval, cooldown time.Duration) {
+	log.Printf("Starting threshold alerts every %s (cooldown %s).", interval, cooldown)
+	a := &alerter{hook: hook, cooldown: cooldown, lastFired: make(map[string]time.Time), alarmStates: make(map[string]string)}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("Threshold alerts stopped.")
+				return
+			case <-ticker.C:
+			}
+
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "/api/ec2-usage", nil)
+			if result, err := fetchEC2Usage(ctx, req); err == nil {
+				a.checkMetrics(ctx, result)
+			} else if ctx.Err() == nil {
+				log.Printf("Alert check failed: %v", err)
+			}
+			if alarms, err := listMetricAlarms(ctx); err == nil {
+				a.checkAlarms(ctx, alarms)
+			} else if ctx.Err() == nil {
+				log.Printf("Alarm check failed: %v", err)
+			}
+		}
+	}()
+}
+
+// checkMetrics alerts on each metric in an ec2-usage result that is at or above
+// its critical level, unless it was alerted on within the cooldown.
+func (a *alerter) checkMetrics(ctx context.Context, result map[string]interface{}) {
+	for id, threshold := range metricThresholds {
+		value, ok := result[id].(float64)
+		if !ok || threshold.severity(value) != "critical" {
+			continue
+		}
+		if time.Since(a.lastFired[id]) < a.cooldown {
+			debugf("Alert for %s suppressed by cooldown", id)
+			continue
+		}
+		timestamp, _ := result[id+"_Timestamp"].(string)
+		instance, _ := result["InstanceID"].(string)
+		p := alertPayload{Metric: id, Value: value, Threshold: threshold.Critical, Instance: instance, Timestamp: timestamp}
+		text := fmt.Sprintf("%s on %s is %.1f (critical at %.1f) at %s", p.Metric, p.Instance, p.Value, p.Threshold, p.Timestamp)
+		if err := a.hook.send(ctx, p, text); err != nil {
+			log.Printf("Error sending alert for %s: %v", id, err)
+			continue
+		}
+		a.lastFired[id] = time.Now()
+		log.Printf("Sent alert: %s=%.1f reached %.1f on %s", id, value, threshold.Critical, instance)
+	}
+}
+
+// checkAlarms alerts on each alarm that went into or out of ALARM since the last
+// check. Alarms seen for the first time only alert when they are in ALARM.
+func (a *alerter) checkAlarms(ctx context.Context, alarms []metricAlarm) {
+	for _, alarm := range alarms {
+		previous := a.alarmStates[alarm.Name]
+		if alarm.State == previous || (alarm.State != "ALARM" && previous != "ALARM") {
+			a.alarmStates[alarm.Name] = alarm.State
+			continue
+		}
+		p := alarmAlertPayload{
+			Alarm:         alarm.Name,
+			State:         alarm.State,
+			PreviousState: previous,
+			Reason:        alarm.Reason,
+			Metric:        alarm.Namespace + "/" + alarm.MetricName,
+			Threshold:     alarm.Threshold,
+			Timestamp:     time.Now().UTC().Format(time.RFC3339),
+		}
+		text := fmt.Sprintf("alarm %s is %s on %s (threshold %.1f)", p.Alarm, p.State, p.Metric, p.Threshold)
+		if err := a.hook.send(ctx, p, text); err != nil {
+			log.Printf("Error sending alert for alarm %s: %v", alarm.Name, err)
+			continue // retried at the next check
+		}
+		a.alarmStates[alarm.Name] = alarm.State
+		log.Printf("Sent alert: alarm %s is %s", alarm.Name, alarm.State)
+	}
+}
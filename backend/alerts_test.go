@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestAlerter returns an alerter posting to a test server, and the bodies it received.
+func newTestAlerter(t *testing.T) (*alerter, *[]map[string]interface{}) {
+	t.Helper()
+	received := make([]map[string]interface{}, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("webhook body is not JSON: %v", err)
+		}
+		received = append(received, body)
+	}))
+	t.Cleanup(server.Close)
+	a := &alerter{hook: alertWebhook{url: server.URL}, cooldown: time.Hour, lastFired: make(map[string]time.Time), alarmStates: make(map[string]string)}
+	return a, &received
+}
+
+func TestAlerterMetricsUseCriticalLevel(t *testing.T) {
+	a, received := newTestAlerter(t)
+	critical := metricThresholds["cpu"].Critical
+
+	a.checkMetrics(context.Background(), map[string]interface{}{"cpu": critical - 1})
+	if len(*received) != 0 {
+		t.Fatalf("alerted below the critical level: %v", *received)
+	}
+	a.checkMetrics(context.Background(), map[string]interface{}{"cpu": critical, "InstanceID": "i-0123456789abcdef0"})
+	if len(*received) != 1 || (*received)[0]["metric"] != "cpu" || (*received)[0]["threshold"] != critical {
+		t.Fatalf("alerts at the critical level = %v, want one for cpu", *received)
+	}
+	a.checkMetrics(context.Background(), map[string]interface{}{"cpu": critical + 5})
+	if len(*received) != 1 {
+		t.Errorf("alerted again within the cooldown: %v", *received)
+	}
+}
+
+func TestAlerterAlarmTransitions(t *testing.T) {
+	a, received := newTestAlerter(t)
+	check := func(state string) {
+		a.checkAlarms(context.Background(), []metricAlarm{{Name: "high-cpu", State: state, Namespace: "AWS/EC2", MetricName: "CPUUtilization"}})
+	}
+
+	check("OK")
+	check("INSUFFICIENT_DATA")
+	if len(*received) != 0 {
+		t.Fatalf("alerted on states other than ALARM: %v", *received)
+	}
+	check("ALARM")
+	check("ALARM")
+	check("OK")
+	if len(*received) != 2 {
+		t.Fatalf("alerts = %v, want one into and one out of ALARM", *received)
+	}
+	if got := (*received)[0]; got["state"] != "ALARM" || got["previous_state"] != "INSUFFICIENT_DATA" || got["metric"] != "AWS/EC2/CPUUtilization" {
+		t.Errorf("first alert = %v", got)
+	}
+	if got := (*received)[1]; got["state"] != "OK" || got["previous_state"] != "ALARM" {
+		t.Errorf("second alert = %v", got)
+	}
+}
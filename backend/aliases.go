@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"strings"
+)
+
+// --- Instance Aliases and Masking ---
+
+// maskInstanceIDs (MASK_INSTANCE_IDS=true) hides raw instance IDs in responses for
+// shared or screenshotted dashboards. CloudWatch is still queried with the real ID.
+var maskInstanceIDs = os.Getenv("MASK_INSTANCE_IDS") == "true"
+
+// instanceAliases maps instance IDs to friendly names from INSTANCE_ALIASES, and
+// aliasInstances maps them back so an alias is accepted wherever an ID is.
+var instanceAliases, aliasInstances = instanceAliasesFromEnv()
+
+// instanceAliasesFromEnv parses INSTANCE_ALIASES, a comma-separated list of ID:Alias
+// pairs such as "i-0abc123:web-1,i-0def456:worker". Aliases may not look like
+// instance IDs, and an ID or alias may only appear once; offending entries are skipped.
+func instanceAliasesFromEnv() (map[string]string, map[string]string) {
+	aliases, ids := make(map[string]string), make(map[string]string)
+	raw := os.Getenv("INSTANCE_ALIASES")
+	if raw == "" {
+		return aliases, ids
+	}
+	for _, entry := range strings.Split(raw, ",") {
+		id, alias, found := strings.Cut(entry, ":")
+		id, alias = strings.TrimSpace(id), strings.TrimSpace(alias)
+		if !found || !strings.HasPrefix(id, "i-") || alias == "" || strings.HasPrefix(alias, "i-") {
+			log.Printf("Ignoring invalid INSTANCE_ALIASES entry %q, expected InstanceID:Alias", entry)
+			continue
+		}
+		if _, dup := aliases[id]; dup {
+			log.Printf("Ignoring duplicate INSTANCE_ALIASES entry for %s", id)
+			continue
+		}
+		if _, dup := ids[alias]; dup {
+			log.Printf("Ignoring duplicate INSTANCE_ALIASES alias %q", alias)
+			continue
+		}
+		aliases[id] = alias
+		ids[alias] = id
+	}
+	return aliases, ids
+}
+
+// resolveInstance returns the instance ID for an INSTANCE_ALIASES alias, and
+// anything else unchanged.
+func resolveInstance(idOrAlias string) string {
+	if id, ok := aliasInstances[idOrAlias]; ok {
+		return id
+	}
+	return idOrAlias
+}
+
+// publicInstanceID is how id appears in responses. With MASK_INSTANCE_IDS it is the
+// instance's alias, or a stable hash for instances without one; otherwise the ID itself.
+func publicInstanceID(id string) string {
+	if !maskInstanceIDs || id == "" {
+		return id
+	}
+	if alias, ok := instanceAliases[id]; ok {
+		return alias
+	}
+	sum := sha256.Sum256([]byte(id))
+	return "instance-" + hex.EncodeToString(sum[:])[:12]
+}
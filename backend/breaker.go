@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/smithy-go"
+)
+
+// --- CloudWatch Circuit Breaker ---
+
+// errCircuitOpen is returned instead of calling CloudWatch while the breaker is open.
+var errCircuitOpen = errors.New("CloudWatch temporarily unavailable")
+
+// circuitBreaker opens after threshold consecutive failures, rejects calls for
+// cooldown, then lets a single trial call through (half-open): success closes
+// it again, failure re-opens it for another cooldown.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	trial     bool // a half-open trial call is in flight
+}
+
+// allow reports whether a call may proceed.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true // closed
+	}
+	if time.Now().Before(b.openUntil) || b.trial {
+		return false // open, or half-open with a trial already running
+	}
+	b.trial = true
+	return true
+}
+
+// record updates the breaker with a call's outcome.
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasOpen := b.failures >= b.threshold
+	b.trial = false
+	if !countsAsOutage(err) {
+		if wasOpen {
+			log.Println("CloudWatch circuit breaker closed: calls are succeeding again.")
+			appReadiness.Set("cloudwatch_circuit", nil)
+		}
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		if !wasOpen {
+			log.Printf("CloudWatch circuit breaker opened after %d consecutive failures (last: %v); retrying in %s.", b.failures, err, b.cooldown)
+		}
+		appReadiness.Set("cloudwatch_circuit", fmt.Errorf("open until %s after %d consecutive failures", b.openUntil.UTC().Format(time.RFC3339), b.failures))
+	}
+}
+
+// countsAsOutage reports whether err suggests CloudWatch itself is struggling:
+// throttling, server faults, and network errors or timeouts. Caller mistakes such
+// as validation errors or missing dashboards don't trip the breaker.
+func countsAsOutage(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if isThrottling(err) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorFault() != smithy.FaultClient
+	}
+	return true
+}
+
+// breakerCloudWatch wraps a CloudWatchAPI so every call goes through the breaker.
+type breakerCloudWatch struct {
+	next    CloudWatchAPI
+	breaker *circuitBreaker
+}
+
+// withCircuitBreaker wraps client using CW_BREAKER_THRESHOLD consecutive failures
+// (default 5) and CW_BREAKER_COOLDOWN (default 30s).
+func withCircuitBreaker(client CloudWatchAPI) CloudWatchAPI {
+	threshold := intFromEnv("CW_BREAKER_THRESHOLD", 5)
+	if threshold < 1 {
+		threshold = 1
+	}
+	appReadiness.Set("cloudwatch_circuit", nil)
+	return &breakerCloudWatch{
+		next: client,
+		breaker: &circuitBreaker{
+			threshold: threshold,
+			cooldown:  durationFromEnv("CW_BREAKER_COOLDOWN", 30*time.Second),
+		},
+	}
+}
+
+// call runs fn within the retry budget if the breaker allows it, and records the
+// outcome. A nil breaker (see cloudWatchForRegion) only applies the retry budget.
+func (c *breakerCloudWatch) call(ctx context.Context, fn func() error) error {
+	if c.breaker != nil && !c.breaker.allow() {
+		return errCircuitOpen
+	}
+	err := withRetryBudget(ctx, fn)
+	if c.breaker != nil {
+		c.breaker.record(err)
+	}
+	return err
+}
+
+func (c *breakerCloudWatch) GetMetricData(ctx context.Context, params *cloudwatch.GetMetricDataInput, optFns ...func(*cloudwatch.Options)) (out *cloudwatch.GetMetricDataOutput, err error) {
+	err = c.call(ctx, func() error {
+		out, err = c.next.GetMetricData(ctx, params, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *breakerCloudWatch) GetMetricStatistics(ctx context.Context, params *cloudwatch.GetMetricStatisticsInput, optFns ...func(*cloudwatch.Options)) (out *cloudwatch.GetMetricStatisticsOutput, err error) {
+	err = c.call(ctx, func() error {
+		out, err = c.next.GetMetricStatistics(ctx, params, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *breakerCloudWatch) ListMetrics(ctx context.Context, params *cloudwatch.ListMetricsInput, optFns ...func(*cloudwatch.Options)) (out *cloudwatch.ListMetricsOutput, err error) {
+	err = c.call(ctx, func() error {
+		out, err = c.next.ListMetrics(ctx, params, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *breakerCloudWatch) ListDashboards(ctx context.Context, params *cloudwatch.ListDashboardsInput, optFns ...func(*cloudwatch.Options)) (out *cloudwatch.ListDashboardsOutput, err error) {
+	err = c.call(ctx, func() error {
+		out, err = c.next.ListDashboards(ctx, params, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *breakerCloudWatch) GetDashboard(ctx context.Context, params *cloudwatch.GetDashboardInput, optFns ...func(*cloudwatch.Options)) (out *cloudwatch.GetDashboardOutput, err error) {
+	err = c.call(ctx, func() error {
+		out, err = c.next.GetDashboard(ctx, params, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *breakerCloudWatch) DescribeAlarms(ctx context.Context, params *cloudwatch.DescribeAlarmsInput, optFns ...func(*cloudwatch.Options)) (out *cloudwatch.DescribeAlarmsOutput, err error) {
+	err = c.call(ctx, func() error {
+		out, err = c.next.DescribeAlarms(ctx, params, optFns...)
+		return err
+	})
+	return out, err
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/budgets"
+	budgettypes "github.com/aws/aws-sdk-go-v2/service/budgets/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// --- AWS Budgets ---
+
+// budgetsCacheTTL is how long /api/budgets results are cached by default. AWS
+// refreshes budget spend only a few times a day.
+const budgetsCacheTTL = time.Hour
+
+// BudgetInfo is one AWS budget with its spend compared to the limit.
+type BudgetInfo struct {
+	Name              string   `json:"name"`
+	Type              string   `json:"type"`
+	TimeUnit          string   `json:"time_unit"`
+	Unit              string   `json:"unit"`                // e.g. USD
+	Limit             *float64 `json:"limit"`               // null for auto-adjusting budgets without a limit
+	Actual            *float64 `json:"actual"`              // null until AWS has calculated spend
+	Forecasted        *float64 `json:"forecasted"`          // null when AWS has no forecast yet
+	PercentUsed       *float64 `json:"percent_used"`        // actual / limit
+	OverLimit         bool     `json:"over_limit"`          // actual spend has reached the limit
+	ForecastOverLimit bool     `json:"forecast_over_limit"` // forecasted spend will exceed the limit
+}
+
+// GobEncode and GobDecode keep a zero spend distinct from an unknown one in the
+// Redis cache; plain gob would decode a pointer to 0 as nil.
+func (b BudgetInfo) GobEncode() ([]byte, error) {
+	return json.Marshal(b)
+}
+
+func (b *BudgetInfo) GobDecode(data []byte) error {
+	return json.Unmarshal(data, b)
+}
+
+// budgetsHandler lists the account's AWS Budgets with limit, actual and forecasted
+// spend, flagging budgets that are, or are forecast to be, over their limit.
+// Results are cached for an hour (CACHE_TTLS "budgets").
+func budgetsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if budgetsClient == nil || stsClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "AWS client not initialized")
+		return
+	}
+
+	cacheKey := cacheKeyFor("budgets", r)
+	if cached, ok := responseCache.Get(cacheKey); ok {
+		writeJSON(w, r, cached)
+		return
+	}
+
+	// DescribeBudgets needs the account ID explicitly; it is the caller's own account.
+	identity, err := stsClient.GetCallerIdentity(r.Context(), &sts.GetCallerIdentityInput{})
+	if err != nil {
+		log.Printf("Error getting AWS caller identity for budgets: %v", err)
+		writeUpstreamError(w, "Failed to get AWS caller identity", err)
+		return
+	}
+
+	infos := make([]BudgetInfo, 0)
+	paginator := budgets.NewDescribeBudgetsPaginator(budgetsClient, &budgets.DescribeBudgetsInput{AccountId: identity.Account})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(r.Context())
+		if err != nil {
+			log.Printf("Error describing AWS budgets: %v", err)
+			writeUpstreamError(w, "Error describing AWS budgets", err)
+			return
+		}
+		for _, b := range page.Budgets {
+			infos = append(infos, budgetInfo(b))
+		}
+	}
+
+	result := map[string]interface{}{
+		"account_id": aws.ToString(identity.Account),
+		"budgets":    infos,
+		"checked_at": time.Now().UTC().Format(time.RFC3339),
+	}
+	responseCache.Set(cacheKey, result, cacheTTL("budgets", budgetsCacheTTL))
+	writeJSON(w, r, result)
+}
+
+// budgetInfo converts an AWS budget to a BudgetInfo.
+func budgetInfo(b budgettypes.Budget) BudgetInfo {
+	info := BudgetInfo{
+		Name:     aws.ToString(b.BudgetName),
+		Type:     string(b.BudgetType),
+		TimeUnit: string(b.TimeUnit),
+	}
+	if b.BudgetLimit != nil {
+		info.Limit = spendAmount(b.BudgetLimit)
+		info.Unit = aws.ToString(b.BudgetLimit.Unit)
+	}
+	if b.CalculatedSpend != nil {
+		info.Actual = spendAmount(b.CalculatedSpend.ActualSpend)
+		info.Forecasted = spendAmount(b.CalculatedSpend.ForecastedSpend)
+		if info.Unit == "" && b.CalculatedSpend.ActualSpend != nil {
+			info.Unit = aws.ToString(b.CalculatedSpend.ActualSpend.Unit)
+		}
+	}
+	if info.Limit != nil && *info.Limit > 0 {
+		if info.Actual != nil {
+			percent := *info.Actual / *info.Limit * 100
+			info.PercentUsed = &percent
+			info.OverLimit = *info.Actual >= *info.Limit
+		}
+		info.ForecastOverLimit = info.Forecasted != nil && *info.Forecasted > *info.Limit
+	}
+	return info
+}
+
+// spendAmount parses a Spend amount, which AWS returns as a decimal string.
+func spendAmount(s *budgettypes.Spend) *float64 {
+	if s == nil || s.Amount == nil {
+		return nil
+	}
+	amount, err := strconv.ParseFloat(*s.Amount, 64)
+	if err != nil {
+		return nil
+	}
+	return &amount
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- In-Memory Cache ---
+
+// cacheEntry is a cached value and the time it stops being fresh.
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// memoryCache is a small TTL cache for expensive upstream results.
+type memoryCache struct {
+	mu         sync.Mutex
+	entries    map[string]cacheEntry
+	refreshing map[string]bool // keys with a background refresh in flight
+}
+
+// newMemoryCache creates an empty cache.
+func newMemoryCache() *memoryCache {
+	return &memoryCache{entries: make(map[string]cacheEntry), refreshing: make(map[string]bool)}
+}
+
+// Get returns the value for key if present and not expired.
+func (c *memoryCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// GetStale returns the value for key even if it has expired. Expired entries are
+// kept until overwritten, which is what lets stale-while-revalidate serve them.
+func (c *memoryCache) GetStale(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// Remaining returns how long key stays fresh, or 0 if it is missing or expired.
+func (c *memoryCache) Remaining(key string) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return 0
+	}
+	if left := time.Until(entry.expires); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// StartRefresh marks key as being refreshed. It returns false if a refresh is already running.
+func (c *memoryCache) StartRefresh(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.refreshing[key] {
+		return false
+	}
+	c.refreshing[key] = true
+	return true
+}
+
+// FinishRefresh clears the in-flight mark set by StartRefresh.
+func (c *memoryCache) FinishRefresh(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.refreshing, key)
+}
+
+// Set stores value under key for ttl.
+func (c *memoryCache) Set(key string, value interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = cacheEntry{value: value, expires: time.Now().Add(ttl)}
+}
+
+// Flush removes every entry and returns how many there were.
+func (c *memoryCache) Flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := len(c.entries)
+	c.entries = make(map[string]cacheEntry)
+	return n
+}
+
+// cache is a TTL cache of upstream results: memoryCache, or redisCache when
+// REDIS_ADDR is set so replicas share results.
+type cache interface {
+	Get(key string) (interface{}, bool)
+	GetStale(key string) (interface{}, bool)
+	Remaining(key string) time.Duration
+	StartRefresh(key string) bool
+	FinishRefresh(key string)
+	Set(key string, value interface{}, ttl time.Duration)
+	Flush() int
+}
+
+// responseCache holds cached upstream results shared by the handlers. It is
+// in-memory unless initResponseCache switches it to Redis.
+var responseCache cache = newMemoryCache()
+
+// cacheTTLs overrides how long results are cached per endpoint, from CACHE_TTLS.
+// Keys are cache key prefixes: "ec2-usage", "stargazers-timeline", "budgets",
+// "alarms", "custom-metric", or "custom-metric:<namespace>" for a single namespace.
+var cacheTTLs = cacheTTLsFromEnv()
+
+// cacheTTLsFromEnv parses CACHE_TTLS, a comma-separated list of name=duration pairs
+// such as "ec2-usage=30s,custom-metric:AWS/S3=1h". Invalid entries are skipped.
+func cacheTTLsFromEnv() map[string]time.Duration {
+	ttls := make(map[string]time.Duration)
+	raw := os.Getenv("CACHE_TTLS")
+	if raw == "" {
+		return ttls
+	}
+	for _, entry := range strings.Split(raw, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(entry), "=")
+		d, err := time.ParseDuration(value)
+		if !found || name == "" || err != nil || d <= 0 {
+			log.Printf("Ignoring invalid CACHE_TTLS entry %q, expected name=duration", entry)
+			continue
+		}
+		ttls[name] = d
+	}
+	return ttls
+}
+
+// cacheTTL returns the CACHE_TTLS lifetime for name, or def when none is configured.
+func cacheTTL(name string, def time.Duration) time.Duration {
+	if ttl, ok := cacheTTLs[name]; ok {
+		return ttl
+	}
+	return def
+}
+
+// ec2CacheTTL is how long EC2 metric results are cached: the "ec2-usage" entry of
+// CACHE_TTLS, else METRIC_CACHE_TTL.
+func ec2CacheTTL() time.Duration {
+	return cacheTTL("ec2-usage", metricCacheTTL)
+}
+
+// customMetricCacheTTL is how long /api/custom-metric results for namespace are
+// cached, so slow-moving metrics such as S3 storage can be cached for longer.
+func customMetricCacheTTL(namespace string) time.Duration {
+	return cacheTTL("custom-metric:"+namespace, cacheTTL("custom-metric", metricCacheTTL))
+}
+
+// cacheFlushHandler empties the response cache and the GitHub conditional request
+// cache so the next request goes upstream, e.g. right after an instance is replaced.
+// It is protected by requireAPIKey. Secrets are read from Vault once at startup
+// and are not cached, so there is nothing to evict for them.
+func cacheFlushHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeErrorJSON(w, http.StatusMethodNotAllowed, codeInvalidRequest, "Use POST to flush the caches")
+		return
+	}
+
+	evicted := map[string]int{"metrics": responseCache.Flush(), "github_etags": 0}
+	if githubETags != nil {
+		evicted["github_etags"] = githubETags.Flush()
+	}
+	log.Printf("Caches flushed on request from %s: %v", clientIP(r), evicted)
+	writeJSON(w, r, map[string]interface{}{"evicted": evicted})
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// --- Percent of Capacity ---
+
+// capacityMetrics maps metrics that report free bytes to the kind of capacity they
+// are a share of, so /api/custom-metric can turn them into a percent used.
+var capacityMetrics = map[string]string{
+	"FreeStorageSpace": "storage", // RDS
+	"FreeableMemory":   "memory",  // RDS, ElastiCache
+	"mem_available":    "memory",  // CloudWatch agent
+}
+
+// resourceCapacity holds configured capacities in bytes by resource and kind, from
+// RESOURCE_CAPACITY.
+var resourceCapacity = resourceCapacityFromEnv()
+
+// resourceCapacityFromEnv parses RESOURCE_CAPACITY, a comma-separated list of
+// resource:kind=size entries such as "mydb:storage=100GiB,i-0abc123:memory=8GiB".
+// The resource is a dimension value (a DB identifier, instance ID or
+// INSTANCE_ALIASES alias) and kind is "storage" or "memory". Malformed entries are
+// skipped.
+func resourceCapacityFromEnv() map[string]map[string]float64 {
+	capacities := make(map[string]map[string]float64)
+	raw := os.Getenv("RESOURCE_CAPACITY")
+	if raw == "" {
+		return capacities
+	}
+	for _, entry := range strings.Split(raw, ",") {
+		resource, spec, found := strings.Cut(entry, ":")
+		kind, size, hasSize := strings.Cut(spec, "=")
+		resource, kind = resolveInstance(strings.TrimSpace(resource)), strings.TrimSpace(kind)
+		bytes, err := parseByteSize(strings.TrimSpace(size))
+		if !found || !hasSize || resource == "" || (kind != "storage" && kind != "memory") || err != nil {
+			log.Printf("Ignoring invalid RESOURCE_CAPACITY entry %q, expected resource:storage=size or resource:memory=size", entry)
+			continue
+		}
+		if capacities[resource] == nil {
+			capacities[resource] = make(map[string]float64)
+		}
+		capacities[resource][kind] = bytes
+	}
+	return capacities
+}
+
+// byteUnits are the size suffixes parseByteSize accepts, longest first so "GiB" is
+// not read as "B".
+var byteUnits = []struct {
+	suffix string
+	bytes  float64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// parseByteSize parses a positive size such as "100GiB", "512MB" or "1073741824".
+func parseByteSize(raw string) (float64, error) {
+	multiplier := 1.0
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(raw, unit.suffix) {
+			raw, multiplier = strings.TrimSpace(strings.TrimSuffix(raw, unit.suffix)), unit.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(raw, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", raw)
+	}
+	return n * multiplier, nil
+}
+
+// instanceMemory caches EC2 instance memory in bytes by instance ID; an instance's
+// type only changes across a stop/start, which is rare enough to ignore.
+var instanceMemory sync.Map
+
+// capacityFor returns the total capacity in bytes behind a free-capacity metric with
+// the given dimensions and where it came from ("config" or "ec2"). It returns 0 when
+// the capacity is not known. Memory of an EC2 instance not in RESOURCE_CAPACITY is
+// looked up from its instance type.
+func capacityFor(ctx context.Context, kind string, dimensions []types.Dimension) (float64, string) {
+	for _, dim := range dimensions {
+		if bytes, ok := resourceCapacity[resolveInstance(*dim.Value)][kind]; ok {
+			return bytes, "config"
+		}
+	}
+	if kind != "memory" {
+		return 0, ""
+	}
+	for _, dim := range dimensions {
+		if *dim.Name != "InstanceId" {
+			continue
+		}
+		bytes, err := ec2InstanceMemory(ctx, *dim.Value)
+		if err != nil {
+			log.Printf("Error looking up memory of %s: %v", *dim.Value, err)
+			return 0, ""
+		}
+		return bytes, "ec2"
+	}
+	return 0, ""
+}
+
+// ec2InstanceMemory returns the memory of an EC2 instance in bytes, from its
+// instance type.
+func ec2InstanceMemory(ctx context.Context, instance string) (float64, error) {
+	if cached, ok := instanceMemory.Load(instance); ok {
+		return cached.(float64), nil
+	}
+	if ec2Client == nil {
+		return 0, errors.New("EC2 client not initialized")
+	}
+	out, err := ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{instance}})
+	if err != nil {
+		return 0, err
+	}
+	if len(out.Reservations) == 0 || len(out.Reservations[0].Instances) == 0 {
+		return 0, fmt.Errorf("instance %s not found", instance)
+	}
+	instanceType := out.Reservations[0].Instances[0].InstanceType
+	described, err := ec2Client.DescribeInstanceTypes(ctx, &ec2.DescribeInstanceTypesInput{InstanceTypes: []ec2types.InstanceType{instanceType}})
+	if err != nil {
+		return 0, err
+	}
+	if len(described.InstanceTypes) == 0 || described.InstanceTypes[0].MemoryInfo == nil || described.InstanceTypes[0].MemoryInfo.SizeInMiB == nil {
+		return 0, fmt.Errorf("no memory information for instance type %s", instanceType)
+	}
+	bytes := float64(*described.InstanceTypes[0].MemoryInfo.SizeInMiB) * (1 << 20)
+	instanceMemory.Store(instance, bytes)
+	return bytes, nil
+}
+
+// percentUsed converts a free amount into the percent of capacity in use.
+func percentUsed(free, capacity float64) float64 {
+	return (capacity - free) / capacity * 100
+}
+
+// addPercentUsed adds "<id>_percent_used" to a custom-metric result for metrics in
+// capacityMetrics, alongside "capacity_bytes" and "capacity_source". It leaves the
+// result alone when the metric is not a free-capacity metric or its capacity is not
+// known.
+func addPercentUsed(ctx context.Context, result map[string]interface{}, id, metricName string, dimensions []types.Dimension) {
+	kind, ok := capacityMetrics[metricName]
+	if !ok {
+		return
+	}
+	capacity, source := capacityFor(ctx, kind, dimensions)
+	if capacity == 0 {
+		debugf("No %s capacity known for %s; skipping percent used", kind, metricName)
+		return
+	}
+	switch v := result[id].(type) {
+	case float64:
+		result[id+"_percent_used"] = percentUsed(v, capacity)
+	case []seriesPoint:
+		points := make([]seriesPoint, len(v))
+		for i, p := range v {
+			points[i] = seriesPoint{Timestamp: p.Timestamp, Value: percentUsed(p.Value, capacity)}
+		}
+		result[id+"_percent_used"] = points
+	default:
+		return // "N/A": no datapoints to compute from
+	}
+	result["capacity_bytes"] = capacity
+	result["capacity_source"] = source
+}
@@ -0,0 +1,1620 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// --- CloudWatch Query Helpers ---
+
+// maxMetricWindow caps ?range= so a single request can't scan months of data.
+const maxMetricWindow = 15 * 24 * time.Hour
+
+// highResPeriods are the sub-minute periods CloudWatch accepts for high-resolution
+// custom metrics. AWS namespaces publish at 60s or coarser.
+var highResPeriods = map[int]bool{1: true, 5: true, 10: true, 30: true}
+
+// highResRetention is how long CloudWatch keeps sub-minute datapoints; older ones
+// are only available aggregated to 60s.
+const highResRetention = 3 * time.Hour
+
+// parseWindowAndPeriod reads the optional ?range= (Go duration with a "d" day
+// extension, e.g. "1h", "7d" or "1d12h") and ?period= (seconds, multiple of 60)
+// query parameters, falling back to the defaults used by the EC2 view. Without
+// ?period=, the period is scaled to the range via defaultPeriodForRange.
+// It returns the query start/end times and period.
+func parseWindowAndPeriod(r *http.Request) (time.Time, time.Time, int32, error) {
+	return parseWindowAndPeriodRes(r, false)
+}
+
+// parseWindowAndPeriodRes is parseWindowAndPeriod that, when highRes is set, also
+// accepts the sub-minute highResPeriods for ranges within highResRetention.
+func parseWindowAndPeriodRes(r *http.Request, highRes bool) (time.Time, time.Time, int32, error) {
+	window := defaultMetricWindow
+	period := defaultMetricPeriod
+
+	if raw := r.URL.Query().Get("range"); raw != "" {
+		d, err := parseRange(raw)
+		if err != nil || d <= 0 {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid 'range' parameter '%s', expected a duration like 10m, 1h or 7d", raw)
+		}
+		if d > maxMetricWindow {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("'range' parameter '%s' exceeds the maximum of %s", raw, maxMetricWindow)
+		}
+		window = d
+		period = defaultPeriodForRange(d)
+	}
+	if raw := r.URL.Query().Get("period"); raw != "" {
+		p, err := strconv.Atoi(raw)
+		switch {
+		case err == nil && highResPeriods[p] && !highRes:
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("'period' parameter '%s' is only supported for custom namespaces, expected a multiple of 60 seconds", raw)
+		case err == nil && highResPeriods[p] && window > highResRetention:
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("'period' parameter '%s' needs a 'range' of at most %s, the retention of high-resolution data", raw, highResRetention)
+		case err == nil && highResPeriods[p]:
+			// A high-resolution period within retention.
+		case err != nil || p < 60 || p%60 != 0:
+			if highRes {
+				return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid 'period' parameter '%s', expected 1, 5, 10, 30 or a multiple of 60 seconds", raw)
+			}
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid 'period' parameter '%s', expected a multiple of 60 seconds", raw)
+		}
+		period = int32(p)
+	}
+
+	endTime := time.Now()
+	return endTime.Add(-window), endTime, period, nil
+}
+
+// isCustomNamespace reports whether namespace holds user-published metrics, which
+// may be high resolution, rather than an AWS service namespace.
+func isCustomNamespace(namespace string) bool {
+	return !strings.HasPrefix(namespace, "AWS/")
+}
+
+// defaultMaxResponsePoints is the MAX_RESPONSE_POINTS default: room for every EC2
+// metric at one-minute resolution over a day, but not for dozens of statistics over 15 days.
+const defaultMaxResponsePoints = 200000
+
+// checkCardinality estimates how many datapoints series queries over [start, end) at
+// period seconds can return, and rejects the request before calling CloudWatch when
+// that exceeds maxResponsePoints.
+func checkCardinality(series int, start, end time.Time, period int32) error {
+	points := int(end.Sub(start) / (time.Duration(period) * time.Second))
+	if points < 1 {
+		points = 1
+	}
+	if total := series * points; total > maxResponsePoints {
+		return fmt.Errorf("request would return about %d datapoints (%d series x %d points), above the limit of %d; narrow 'range', raise 'period' or request fewer instances or statistics", total, series, points, maxResponsePoints)
+	}
+	return nil
+}
+
+// parseRange parses a Go duration that may start with a whole number of days,
+// e.g. "7d" or "1d12h".
+func parseRange(raw string) (time.Duration, error) {
+	days, rest, found := strings.Cut(raw, "d")
+	if !found {
+		return time.ParseDuration(raw)
+	}
+	n, err := strconv.Atoi(days)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid day count in '%s'", raw)
+	}
+	d := time.Duration(n) * 24 * time.Hour
+	if rest != "" {
+		extra, err := time.ParseDuration(rest)
+		if err != nil {
+			return 0, err
+		}
+		d += extra
+	}
+	return d, nil
+}
+
+// defaultPeriodForRange picks a period that keeps a series at a readable number of
+// points: 1-minute data up to an hour, 5-minute up to a day, hourly beyond that.
+func defaultPeriodForRange(d time.Duration) int32 {
+	switch {
+	case d <= time.Hour:
+		return 60
+	case d <= 24*time.Hour:
+		return 300
+	default:
+		return 3600
+	}
+}
+
+// maxMetricDataPages caps how many GetMetricData pages getAllMetricData will follow.
+const maxMetricDataPages = 20
+
+// getAllMetricData calls GetMetricData and follows NextToken, merging each query's
+// values and timestamps across pages so long windows aren't silently cut short.
+// Page order follows the requested ScanBy, so appending keeps each result sorted.
+func getAllMetricData(ctx context.Context, input *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+	return getAllMetricDataFrom(ctx, cwClient, input)
+}
+
+// getAllMetricDataFrom is getAllMetricData against a specific client, such as
+// another region's from cloudWatchForRegion.
+func getAllMetricDataFrom(ctx context.Context, client CloudWatchAPI, input *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+	merged := &cloudwatch.GetMetricDataOutput{}
+	byID := make(map[string]int) // query ID -> index in merged.MetricDataResults
+	params := *input
+	for page := 1; ; page++ {
+		resp, err := client.GetMetricData(ctx, &params)
+		if err != nil {
+			return nil, err
+		}
+		for _, mdr := range resp.MetricDataResults {
+			id := aws.ToString(mdr.Id)
+			i, seen := byID[id]
+			if !seen {
+				byID[id] = len(merged.MetricDataResults)
+				merged.MetricDataResults = append(merged.MetricDataResults, mdr)
+				continue
+			}
+			existing := &merged.MetricDataResults[i]
+			existing.Values = append(existing.Values, mdr.Values...)
+			existing.Timestamps = append(existing.Timestamps, mdr.Timestamps...)
+			existing.StatusCode = mdr.StatusCode
+			existing.Messages = append(existing.Messages, mdr.Messages...)
+		}
+		merged.Messages = append(merged.Messages, resp.Messages...)
+
+		if resp.NextToken == nil {
+			if page > 1 {
+				log.Printf("GetMetricData response assembled from %d pages", page)
+			}
+			return merged, nil
+		}
+		if page >= maxMetricDataPages {
+			log.Printf("GetMetricData still paginating after %d pages; returning partial data", page)
+			return merged, nil
+		}
+		params.NextToken = resp.NextToken
+	}
+}
+
+// parseScanBy picks the datapoint order requested from CloudWatch: ascending for
+// series mode (already chronological), descending for latest mode (newest first),
+// or whatever ?scan=asc|desc asks for.
+func parseScanBy(r *http.Request, mode string) (types.ScanBy, error) {
+	switch scan := r.URL.Query().Get("scan"); scan {
+	case "":
+		if mode == "series" {
+			return types.ScanByTimestampAscending, nil
+		}
+		return types.ScanByTimestampDescending, nil
+	case "asc":
+		return types.ScanByTimestampAscending, nil
+	case "desc":
+		return types.ScanByTimestampDescending, nil
+	default:
+		return "", fmt.Errorf("invalid 'scan' parameter '%s', expected 'asc' or 'desc'", scan)
+	}
+}
+
+// parseLabelOptions reads ?tz=+0530 so timestamps in CloudWatch-generated labels
+// match the display timezone. It returns nil (UTC labels) when tz is absent.
+func parseLabelOptions(r *http.Request) (*types.LabelOptions, error) {
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		return nil, nil
+	}
+	valid := len(tz) == 5 && (tz[0] == '+' || tz[0] == '-')
+	for _, c := range tz[1:] {
+		valid = valid && c >= '0' && c <= '9'
+	}
+	if !valid {
+		return nil, fmt.Errorf("invalid 'tz' parameter '%s', expected a UTC offset like +0530 or -0800", tz)
+	}
+	return &types.LabelOptions{Timezone: aws.String(tz)}, nil
+}
+
+// parseAccountID reads the optional ?account= parameter, which must be a 12-digit AWS account ID.
+func parseAccountID(r *http.Request) (string, error) {
+	account := r.URL.Query().Get("account")
+	if account == "" {
+		return "", nil
+	}
+	if len(account) != 12 {
+		return "", fmt.Errorf("invalid 'account' parameter '%s', expected a 12-digit AWS account ID", account)
+	}
+	for _, c := range account {
+		if c < '0' || c > '9' {
+			return "", fmt.Errorf("invalid 'account' parameter '%s', expected a 12-digit AWS account ID", account)
+		}
+	}
+	return account, nil
+}
+
+// addLatestValues copies the most recent datapoint of each result into the
+// response map using the same "<id>" / "<id>_Timestamp" / "N/A" shape as ec2UsageHandler.
+// Results must have been fetched with ScanByTimestampDescending.
+func addLatestValues(result map[string]interface{}, results []types.MetricDataResult) {
+	for _, mdr := range results {
+		id := *mdr.Id
+		result[id+"_label"] = metricLabel(mdr)
+		if len(mdr.Values) > 0 {
+			result[id] = mdr.Values[0]
+			result[id+"_Timestamp"] = mdr.Timestamps[0].Format(time.RFC3339)
+		} else {
+			result[id] = "N/A"
+		}
+	}
+}
+
+// metricLabel returns CloudWatch's display label for a result: the query's Label or
+// expression label when set, otherwise the metric name. It falls back to the ID.
+func metricLabel(mdr types.MetricDataResult) string {
+	if label := aws.ToString(mdr.Label); label != "" {
+		return label
+	}
+	return aws.ToString(mdr.Id)
+}
+
+// hasAnyValues reports whether any result contains at least one datapoint.
+func hasAnyValues(results []types.MetricDataResult) bool {
+	for _, mdr := range results {
+		if len(mdr.Values) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hasValues reports whether any of the results with the given IDs contains at least one datapoint.
+// Statistic-suffixed IDs from ?stat= (e.g. "memUsed_Maximum") count as their base ID.
+func hasValues(results []types.MetricDataResult, ids ...string) bool {
+	for _, mdr := range results {
+		for _, id := range ids {
+			if metricBaseID(aws.ToString(mdr.Id)) == id && len(mdr.Values) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// maxMetricQueries caps how many queries a single request may expand into via ?stat=.
+const maxMetricQueries = 40
+
+// validStats are the CloudWatch statistics accepted by ?stat=, besides percentiles like p99.
+var validStats = map[string]bool{
+	"Average":     true,
+	"Sum":         true,
+	"Minimum":     true,
+	"Maximum":     true,
+	"SampleCount": true,
+}
+
+// parseStats reads ?stat=Average,Maximum. It returns nil when the parameter is
+// absent, meaning each metric keeps its default statistic.
+func parseStats(r *http.Request) ([]string, error) {
+	raw := r.URL.Query().Get("stat")
+	if raw == "" {
+		return nil, nil
+	}
+	var stats []string
+	seen := make(map[string]bool)
+	for _, stat := range strings.Split(raw, ",") {
+		stat = strings.TrimSpace(stat)
+		if !validStats[stat] && !isPercentileStat(stat) {
+			return nil, fmt.Errorf("invalid statistic '%s' in 'stat' parameter, expected Average, Sum, Minimum, Maximum, SampleCount or a percentile like p99", stat)
+		}
+		if !seen[stat] {
+			seen[stat] = true
+			stats = append(stats, stat)
+		}
+	}
+	return stats, nil
+}
+
+// isPercentileStat reports whether stat is a percentile statistic such as p90 or p99.9.
+func isPercentileStat(stat string) bool {
+	if !strings.HasPrefix(stat, "p") {
+		return false
+	}
+	p, err := strconv.ParseFloat(stat[1:], 64)
+	return err == nil && p >= 0 && p <= 100
+}
+
+// expandStats replaces each query with one query per statistic, with IDs like
+// "cpu_Average" and "cpu_Maximum". Percentile IDs use "_" for the decimal point,
+// since query IDs may only contain letters, digits and underscores.
+func expandStats(queries []types.MetricDataQuery, stats []string) ([]types.MetricDataQuery, error) {
+	if len(queries)*len(stats) > maxMetricQueries {
+		return nil, fmt.Errorf("'stat' parameter expands to %d queries, exceeding the maximum of %d", len(queries)*len(stats), maxMetricQueries)
+	}
+	expanded := make([]types.MetricDataQuery, 0, len(queries)*len(stats))
+	for _, q := range queries {
+		for _, stat := range stats {
+			metricStat := *q.MetricStat
+			metricStat.Stat = aws.String(stat)
+			eq := q
+			eq.Id = aws.String(aws.ToString(q.Id) + "_" + strings.ReplaceAll(stat, ".", "_"))
+			eq.MetricStat = &metricStat
+			expanded = append(expanded, eq)
+		}
+	}
+	return expanded, nil
+}
+
+// metricBaseID strips a statistic suffix added by expandStats ("cpu_Maximum" -> "cpu").
+func metricBaseID(id string) string {
+	base, _, _ := strings.Cut(id, "_")
+	return base
+}
+
+// metricUnits maps query IDs to the CloudWatch unit of the underlying metric.
+// GetMetricData results don't carry a unit, so it is recorded here alongside the queries.
+var metricUnits = map[string]string{
+	"cpu":       "Percent",
+	"memUsed":   "Percent",
+	"diskUsed":  "Percent",
+	"netIn":     "Bytes",
+	"netOut":    "Bytes",
+	"inService": "Count",
+}
+
+// emptyMetricMessages explains, per base metric ID, why a query that CloudWatch
+// answered can still have no datapoints in the window.
+var emptyMetricMessages = map[string]string{
+	"cpu":       "No CPU datapoints in this window. The instance may be new or stopped, or the period may be shorter than its monitoring interval (5 minutes without detailed monitoring).",
+	"netIn":     "No NetworkIn datapoints in this window. The instance may be new or stopped, or the period may be shorter than its monitoring interval (5 minutes without detailed monitoring).",
+	"netOut":    "No NetworkOut datapoints in this window. The instance may be new or stopped, or the period may be shorter than its monitoring interval (5 minutes without detailed monitoring).",
+	"memUsed":   "No memory datapoints in this window. Memory is only published by the CloudWatch agent; check that it is installed and reports mem_used_percent with these dimensions.",
+	"diskUsed":  "No disk datapoints in this window. Disk usage is only published by the CloudWatch agent; check that it is installed and reports disk_used_percent for this path.",
+	"inService": "No in-service instance counts in this window. Group metrics collection must be enabled on the Auto Scaling group.",
+}
+
+// emptyMetricMessage explains an empty result for metric id, falling back to a
+// generic reason for metrics without a specific one.
+func emptyMetricMessage(id string) string {
+	if msg, ok := emptyMetricMessages[metricBaseID(id)]; ok {
+		return msg
+	}
+	return "No datapoints in this window. The metric may not be published yet for these dimensions."
+}
+
+// metricLabels maps metric IDs to display names, e.g. "netIn" to "Network In",
+// from METRIC_LABELS.
+var metricLabels = metricLabelsFromEnv()
+
+// metricLabelsFromEnv parses METRIC_LABELS, a comma-separated list of ID:Label
+// pairs such as "cpu:CPU,netIn:Network In". Malformed entries are skipped.
+func metricLabelsFromEnv() map[string]string {
+	labels := make(map[string]string)
+	raw := os.Getenv("METRIC_LABELS")
+	if raw == "" {
+		return labels
+	}
+	for _, entry := range strings.Split(raw, ",") {
+		id, label, found := strings.Cut(entry, ":")
+		id, label = strings.TrimSpace(id), strings.TrimSpace(label)
+		if !found || id == "" || label == "" {
+			log.Printf("Ignoring invalid METRIC_LABELS entry %q, expected ID:Label", entry)
+			continue
+		}
+		labels[id] = label
+	}
+	return labels
+}
+
+// metricThreshold is the warning and critical level for a metric. A value at or
+// above a level has that severity.
+type metricThreshold struct {
+	Warn     float64 `json:"warn"`
+	Critical float64 `json:"critical"`
+}
+
+// severity classifies v as "ok", "warning" or "critical".
+func (t metricThreshold) severity(v float64) string {
+	switch {
+	case v >= t.Critical:
+		return "critical"
+	case v >= t.Warn:
+		return "warning"
+	}
+	return "ok"
+}
+
+// metricThresholds are the per-metric levels returned with ec2-usage values, and
+// whose critical levels trigger alerts, from the defaults below and METRIC_THRESHOLDS.
+var metricThresholds = metricThresholdsFromEnv()
+
+// metricThresholdsFromEnv starts from defaults for the percentage metrics and applies
+// METRIC_THRESHOLDS, a comma-separated list of id=warn:critical pairs such as
+// "cpu=60:85,netIn=5e8:1e9". Malformed entries are skipped.
+func metricThresholdsFromEnv() map[string]metricThreshold {
+	thresholds := map[string]metricThreshold{
+		"cpu":      {Warn: 70, Critical: 90},
+		"memUsed":  {Warn: 80, Critical: 95},
+		"diskUsed": {Warn: 80, Critical: 95},
+	}
+	raw := os.Getenv("METRIC_THRESHOLDS")
+	if raw == "" {
+		return thresholds
+	}
+	for _, entry := range strings.Split(raw, ",") {
+		id, levels, found := strings.Cut(strings.TrimSpace(entry), "=")
+		rawWarn, rawCritical, hasBoth := strings.Cut(levels, ":")
+		warn, errWarn := strconv.ParseFloat(rawWarn, 64)
+		critical, errCritical := strconv.ParseFloat(rawCritical, 64)
+		if !found || !hasBoth || id == "" || errWarn != nil || errCritical != nil || warn > critical {
+			log.Printf("Ignoring invalid METRIC_THRESHOLDS entry %q, expected id=warn:critical", entry)
+			continue
+		}
+		thresholds[id] = metricThreshold{Warn: warn, Critical: critical}
+	}
+	return thresholds
+}
+
+// displayLabel returns the configured label for a query ID. A ?stat= suffixed ID
+// like "cpu_Maximum" uses its base ID's label, as "CPU (Maximum)". Unmapped IDs are
+// returned unchanged.
+func displayLabel(id string) string {
+	if label, ok := metricLabels[id]; ok {
+		return label
+	}
+	if base, stat, found := strings.Cut(id, "_"); found {
+		if label, ok := metricLabels[base]; ok {
+			return label + " (" + stat + ")"
+		}
+	}
+	return id
+}
+
+// isByteSum reports whether a query ID holds NetworkIn/NetworkOut byte sums per
+// period, which is their default statistic.
+func isByteSum(id string) bool {
+	base, stat, _ := strings.Cut(id, "_")
+	return (base == "netIn" || base == "netOut") && (stat == "" || stat == "Sum")
+}
+
+// bitsPerSecond converts a byte sum over periodSeconds into an average bit rate.
+func bitsPerSecond(sumBytes float64, periodSeconds int32) float64 {
+	return sumBytes * 8 / float64(periodSeconds)
+}
+
+// humanizeValue formats a value for display according to its CloudWatch unit,
+// e.g. 1258291 Bytes -> "1.2 MB" and 12.345 Percent -> "12.3%".
+func humanizeValue(v float64, unit string) string {
+	switch unit {
+	case "Percent":
+		return fmt.Sprintf("%.1f%%", v)
+	case "Bytes":
+		suffixes := []string{"B", "KB", "MB", "GB", "TB"}
+		i := 0
+		for v >= 1024 && i < len(suffixes)-1 {
+			v /= 1024
+			i++
+		}
+		if i == 0 {
+			return fmt.Sprintf("%.0f %s", v, suffixes[i])
+		}
+		return fmt.Sprintf("%.1f %s", v, suffixes[i])
+	case "Bits/Second":
+		suffixes := []string{"bps", "Kbps", "Mbps", "Gbps"}
+		i := 0
+		for v >= 1000 && i < len(suffixes)-1 {
+			v /= 1000
+			i++
+		}
+		return fmt.Sprintf("%.1f %s", v, suffixes[i])
+	case "Count":
+		return fmt.Sprintf("%.0f", v)
+	default:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+}
+
+// seriesPoint is one datapoint in a series-mode response.
+type seriesPoint struct {
+	Timestamp string  `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// seriesFromResult converts a result into chronologically ordered points,
+// regardless of the ScanBy order CloudWatch returned them in.
+func seriesFromResult(mdr types.MetricDataResult) []seriesPoint {
+	points := make([]seriesPoint, 0, len(mdr.Values))
+	for i, v := range mdr.Values {
+		points = append(points, seriesPoint{Timestamp: mdr.Timestamps[i].Format(time.RFC3339), Value: v})
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Timestamp < points[j].Timestamp })
+	return points
+}
+
+// smoothingFunc turns a chronological list of values into a smoothed list of the same length.
+type smoothingFunc func([]float64) []float64
+
+// parseSmoothing reads ?smooth=ewma&alpha=0.3 or ?smooth=sma&window=5.
+// It returns nil when no smoothing was requested (raw data only).
+func parseSmoothing(r *http.Request) (smoothingFunc, error) {
+	q := r.URL.Query()
+	switch q.Get("smooth") {
+	case "":
+		return nil, nil
+	case "ewma":
+		alpha := 0.3
+		if raw := q.Get("alpha"); raw != "" {
+			a, err := strconv.ParseFloat(raw, 64)
+			if err != nil || a <= 0 || a > 1 {
+				return nil, fmt.Errorf("invalid 'alpha' parameter '%s', expected a number in (0, 1]", raw)
+			}
+			alpha = a
+		}
+		return func(values []float64) []float64 { return ewma(values, alpha) }, nil
+	case "sma":
+		window := 5
+		if raw := q.Get("window"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 2 || n > 100 {
+				return nil, fmt.Errorf("invalid 'window' parameter '%s', expected an integer between 2 and 100", raw)
+			}
+			window = n
+		}
+		return func(values []float64) []float64 { return sma(values, window) }, nil
+	default:
+		return nil, fmt.Errorf("invalid 'smooth' parameter '%s', expected 'ewma' or 'sma'", q.Get("smooth"))
+	}
+}
+
+// ewma computes an exponentially weighted moving average seeded with the first value.
+func ewma(values []float64, alpha float64) []float64 {
+	out := make([]float64, len(values))
+	for i, v := range values {
+		if i == 0 {
+			out[i] = v
+			continue
+		}
+		out[i] = alpha*v + (1-alpha)*out[i-1]
+	}
+	return out
+}
+
+// sma computes a trailing simple moving average. The first window-1 points
+// average over however many values are available so far.
+func sma(values []float64, window int) []float64 {
+	out := make([]float64, len(values))
+	sum := 0.0
+	for i, v := range values {
+		sum += v
+		if i >= window {
+			sum -= values[i-window]
+		}
+		n := window
+		if i+1 < window {
+			n = i + 1
+		}
+		out[i] = sum / float64(n)
+	}
+	return out
+}
+
+// smoothSeries applies smoother to the values of points, keeping their timestamps.
+func smoothSeries(points []seriesPoint, smoother smoothingFunc) []seriesPoint {
+	values := make([]float64, len(points))
+	for i, p := range points {
+		values[i] = p.Value
+	}
+	smoothed := smoother(values)
+	out := make([]seriesPoint, len(points))
+	for i, p := range points {
+		out[i] = seriesPoint{Timestamp: p.Timestamp, Value: smoothed[i]}
+	}
+	return out
+}
+
+// cwAgentDimensions builds the InstanceId/ImageId/InstanceType dimension set the
+// CloudWatch agent attaches via append_dimensions. ImageId and InstanceType come
+// from the metadata service.
+func cwAgentDimensions(instance string) ([]types.Dimension, error) {
+	imageID, err := fetchMetadata("ami-id")
+	if err != nil {
+		return nil, err
+	}
+	instanceType, err := fetchMetadata("instance-type")
+	if err != nil {
+		return nil, err
+	}
+	return []types.Dimension{
+		{Name: aws.String("InstanceId"), Value: aws.String(instance)},
+		{Name: aws.String("ImageId"), Value: aws.String(imageID)},
+		{Name: aws.String("InstanceType"), Value: aws.String(instanceType)},
+	}, nil
+}
+
+// cwAgentDiskDimensions extends the agent dimensions with the disk plugin's
+// path/device/fstype. Defaults match a typical Amazon Linux root volume and can
+// be overridden with ?path=, ?device= and ?fstype=.
+func cwAgentDiskDimensions(agentDimensions []types.Dimension, r *http.Request) []types.Dimension {
+	queryOr := func(name, def string) string {
+		if v := r.URL.Query().Get(name); v != "" {
+			return v
+		}
+		return def
+	}
+	dims := append([]types.Dimension{}, agentDimensions...)
+	return append(dims,
+		types.Dimension{Name: aws.String("path"), Value: aws.String(queryOr("path", "/"))},
+		types.Dimension{Name: aws.String("device"), Value: aws.String(queryOr("device", "nvme0n1p1"))},
+		types.Dimension{Name: aws.String("fstype"), Value: aws.String(queryOr("fstype", "xfs"))},
+	)
+}
+
+// --- CloudWatch Handlers ---
+
+// natUsageHandler fetches traffic and connection metrics for a NAT gateway (?id=nat-abc),
+// giving early warning of runaway NAT data-processing charges.
+func natUsageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if cwClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "AWS client not initialized")
+		return
+	}
+
+	natID := r.URL.Query().Get("id")
+	if !strings.HasPrefix(natID, "nat-") {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, "Missing or invalid 'id' query parameter, expected a NAT gateway ID like nat-0abc123")
+		return
+	}
+
+	startTime, endTime, period, err := parseWindowAndPeriod(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	dimensions := []types.Dimension{{Name: aws.String("NatGatewayId"), Value: aws.String(natID)}}
+	natQuery := func(id, metricName, stat string) types.MetricDataQuery {
+		return types.MetricDataQuery{
+			Id: aws.String(id),
+			MetricStat: &types.MetricStat{
+				Metric: &types.Metric{
+					Namespace:  aws.String("AWS/NATGateway"),
+					MetricName: aws.String(metricName),
+					Dimensions: dimensions,
+				},
+				Period: aws.Int32(period),
+				Stat:   aws.String(stat),
+			},
+			ReturnData: aws.Bool(true),
+		}
+	}
+
+	resp, err := getAllMetricData(context.TODO(), &cloudwatch.GetMetricDataInput{
+		StartTime: &startTime,
+		EndTime:   &endTime,
+		MetricDataQueries: []types.MetricDataQuery{
+			natQuery("bytesOut", "BytesOutToDestination", "Sum"),
+			natQuery("bytesIn", "BytesInFromDestination", "Sum"),
+			natQuery("activeConnections", "ActiveConnectionCount", "Maximum"),
+		},
+		ScanBy: types.ScanByTimestampDescending,
+	})
+	if err != nil {
+		log.Printf("Error getting NAT gateway CloudWatch data: %v", err)
+		writeUpstreamError(w, "Error getting NAT gateway CloudWatch data", err)
+		return
+	}
+
+	result := make(map[string]interface{})
+	result["NatGatewayId"] = natID
+	addLatestValues(result, resp.MetricDataResults)
+
+	writeJSON(w, r, result)
+}
+
+// defaultIdleCPUThreshold is the 7-day average CPU (percent) below which an
+// instance is flagged as idle. Override with IDLE_CPU_THRESHOLD or ?threshold=.
+const defaultIdleCPUThreshold = 5.0
+
+// idleCPUThreshold is IDLE_CPU_THRESHOLD, or defaultIdleCPUThreshold when it is
+// unset or invalid.
+var idleCPUThreshold = idleCPUThresholdFromEnv()
+
+// idleCPUThresholdFromEnv parses IDLE_CPU_THRESHOLD, a percentage in (0, 100].
+func idleCPUThresholdFromEnv() float64 {
+	raw := os.Getenv("IDLE_CPU_THRESHOLD")
+	if raw == "" {
+		return defaultIdleCPUThreshold
+	}
+	t, err := strconv.ParseFloat(raw, 64)
+	if err != nil || t <= 0 || t > 100 {
+		log.Printf("Invalid IDLE_CPU_THRESHOLD=%q, using %.0f%%", raw, defaultIdleCPUThreshold)
+		return defaultIdleCPUThreshold
+	}
+	return t
+}
+
+// defaultTrendThreshold is the percent change in CPU below which /api/ec2-trend reports "flat".
+const defaultTrendThreshold = 10.0
+
+// averageCPU returns the instance's average CPUUtilization over [start, end), or nil
+// when CloudWatch has no datapoints for that window.
+func averageCPU(ctx context.Context, instance string, start, end time.Time) (*float64, error) {
+	period := int32(end.Sub(start) / time.Second)
+	resp, err := getAllMetricData(ctx, &cloudwatch.GetMetricDataInput{
+		StartTime: &start,
+		EndTime:   &end,
+		MetricDataQueries: []types.MetricDataQuery{{
+			Id: aws.String("cpu"),
+			MetricStat: &types.MetricStat{
+				Metric: &types.Metric{
+					Namespace:  aws.String("AWS/EC2"),
+					MetricName: aws.String("CPUUtilization"),
+					Dimensions: []types.Dimension{{Name: aws.String("InstanceId"), Value: aws.String(instance)}},
+				},
+				Period: aws.Int32(period),
+				Stat:   aws.String("Average"),
+			},
+			ReturnData: aws.Bool(true),
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, mdr := range resp.MetricDataResults {
+		if len(mdr.Values) == 0 {
+			continue
+		}
+		sum := 0.0
+		for _, v := range mdr.Values {
+			sum += v
+		}
+		avg := sum / float64(len(mdr.Values))
+		return &avg, nil
+	}
+	return nil, nil
+}
+
+// ec2TrendHandler compares the instance's average CPU over the last hour with the
+// same hour yesterday and reports the change as "up", "down" or "flat". Changes
+// within the threshold percentage (TREND_THRESHOLD or ?threshold=, default 10) are flat.
+func ec2TrendHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if cwClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "AWS client not initialized")
+		return
+	}
+
+	instance, err := requestInstanceID(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	if instance == "" {
+		writeErrorJSON(w, http.StatusServiceUnavailable, codeUnavailable, "EC2 Instance ID not determined. Pass 'instance' explicitly.")
+		return
+	}
+
+	threshold := defaultTrendThreshold
+	if raw := os.Getenv("TREND_THRESHOLD"); raw != "" {
+		if t, err := strconv.ParseFloat(raw, 64); err == nil && t >= 0 {
+			threshold = t
+		} else {
+			log.Printf("Invalid TREND_THRESHOLD=%q, using %.0f%%", raw, defaultTrendThreshold)
+		}
+	}
+	if raw := r.URL.Query().Get("threshold"); raw != "" {
+		t, err := strconv.ParseFloat(raw, 64)
+		if err != nil || t < 0 {
+			writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("invalid 'threshold' parameter '%s', expected a non-negative percentage", raw))
+			return
+		}
+		threshold = t
+	}
+
+	// Whole minutes keep both windows aligned to CloudWatch's one-minute datapoints.
+	end := time.Now().Truncate(time.Minute)
+	start := end.Add(-time.Hour)
+	var previous *float64
+	current, err := averageCPU(r.Context(), instance, start, end)
+	if err == nil {
+		previous, err = averageCPU(r.Context(), instance, start.Add(-24*time.Hour), end.Add(-24*time.Hour))
+	}
+	if err != nil {
+		log.Printf("Error getting CloudWatch data for CPU trend: %v", err)
+		writeUpstreamError(w, "Error getting CloudWatch data", err)
+		return
+	}
+
+	writeJSON(w, r, cpuTrend(publicInstanceID(instance), current, previous, threshold))
+}
+
+// cpuTrend builds the ec2-trend response. Delta and percent change are null when
+// either window has no data, and percent change also when yesterday's average was 0.
+func cpuTrend(instance string, current, previous *float64, threshold float64) map[string]interface{} {
+	result := map[string]interface{}{
+		"instanceId":     instance,
+		"current":        current,
+		"previous":       previous,
+		"delta":          nil,
+		"percent_change": nil,
+		"threshold":      threshold,
+		"trend":          "unknown",
+	}
+	if current == nil || previous == nil {
+		return result
+	}
+	delta := *current - *previous
+	result["delta"] = delta
+	if *previous == 0 {
+		result["trend"] = "flat"
+		if delta > 0 {
+			result["trend"] = "up"
+		}
+		return result
+	}
+	change := delta / *previous * 100
+	result["percent_change"] = change
+	switch {
+	case change > threshold:
+		result["trend"] = "up"
+	case change < -threshold:
+		result["trend"] = "down"
+	default:
+		result["trend"] = "flat"
+	}
+	return result
+}
+
+// idleLookback is how far back ec2IdleHandler averages CPU.
+const idleLookback = 7 * 24 * time.Hour
+
+// ec2IdleHandler flags underutilized instances: those whose average CPU over the last
+// 7 days is below the threshold. It checks the monitored instance by default, or a
+// comma-separated ?instances=i-a,i-b list.
+func ec2IdleHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if cwClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "AWS client not initialized")
+		return
+	}
+
+	var instances []string
+	if raw := r.URL.Query().Get("instances"); raw != "" {
+		for _, id := range strings.Split(raw, ",") {
+			id = resolveInstance(strings.TrimSpace(id))
+			if !strings.HasPrefix(id, "i-") {
+				writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("invalid instance ID '%s' in 'instances' parameter", id))
+				return
+			}
+			instances = append(instances, id)
+		}
+	} else if id := currentInstanceID(); id != "" {
+		instances = []string{id}
+	} else {
+		writeErrorJSON(w, http.StatusServiceUnavailable, codeUnavailable, "EC2 Instance ID not determined. Pass 'instances' explicitly.")
+		return
+	}
+	if len(instances) > maxMetricQueries {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("at most %d instances can be checked per request", maxMetricQueries))
+		return
+	}
+
+	threshold := idleCPUThreshold
+	if raw := r.URL.Query().Get("threshold"); raw != "" {
+		t, err := strconv.ParseFloat(raw, 64)
+		if err != nil || t <= 0 || t > 100 {
+			writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("invalid 'threshold' parameter '%s', expected a percentage in (0, 100]", raw))
+			return
+		}
+		threshold = t
+	}
+
+	// Daily averages over whole UTC days, combined below; one 7-day period could
+	// straddle two buckets.
+	queries := make([]types.MetricDataQuery, 0, len(instances))
+	for i, id := range instances {
+		queries = append(queries, types.MetricDataQuery{
+			Id: aws.String(fmt.Sprintf("cpu%d", i)),
+			MetricStat: &types.MetricStat{
+				Metric: &types.Metric{
+					Namespace:  aws.String("AWS/EC2"),
+					MetricName: aws.String("CPUUtilization"),
+					Dimensions: []types.Dimension{{Name: aws.String("InstanceId"), Value: aws.String(id)}},
+				},
+				Period: aws.Int32(86400),
+				Stat:   aws.String("Average"),
+			},
+			ReturnData: aws.Bool(true),
+		})
+	}
+
+	// CloudWatch aligns 86400s buckets to midnight UTC. Ending the window there too
+	// keeps partial days at either end, which would weigh as much as a full day in
+	// the average, out of it.
+	endTime := time.Now().UTC().Truncate(24 * time.Hour)
+	startTime := endTime.Add(-idleLookback)
+	if err := checkCardinality(len(queries), startTime, endTime, 86400); err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	resp, err := getAllMetricData(r.Context(), &cloudwatch.GetMetricDataInput{
+		StartTime:         &startTime,
+		EndTime:           &endTime,
+		MetricDataQueries: queries,
+	})
+	if err != nil {
+		log.Printf("Error getting CloudWatch data for idle check: %v", err)
+		writeUpstreamError(w, "Error getting CloudWatch data", err)
+		return
+	}
+
+	averages := make(map[string][]float64, len(resp.MetricDataResults))
+	for _, mdr := range resp.MetricDataResults {
+		averages[aws.ToString(mdr.Id)] = mdr.Values
+	}
+
+	type IdleInfo struct {
+		InstanceID string   `json:"instanceId"`
+		AverageCPU *float64 `json:"averageCpu"` // null when there is no data
+		Idle       bool     `json:"idle"`
+	}
+	results := make([]IdleInfo, 0, len(instances))
+	for i, id := range instances {
+		info := IdleInfo{InstanceID: publicInstanceID(id)}
+		if values := averages[fmt.Sprintf("cpu%d", i)]; len(values) > 0 {
+			sum := 0.0
+			for _, v := range values {
+				sum += v
+			}
+			avg := sum / float64(len(values))
+			info.AverageCPU = &avg
+			info.Idle = avg < threshold
+		}
+		results = append(results, info)
+	}
+
+	writeJSON(w, r, map[string]interface{}{
+		"lookback":  "7d",
+		"threshold": threshold,
+		"instances": results,
+	})
+}
+
+// promMetrics maps ec2-usage query IDs to Prometheus metric names and help text.
+var promMetrics = map[string][2]string{
+	"cpu":       {"cloudpulse_ec2_cpu_utilization_percent", "Average CPU utilization of the instance or group."},
+	"memUsed":   {"cloudpulse_ec2_memory_used_percent", "Memory used, from the CloudWatch agent."},
+	"diskUsed":  {"cloudpulse_ec2_disk_used_percent", "Disk used, from the CloudWatch agent."},
+	"netIn":     {"cloudpulse_ec2_network_in_bytes", "Bytes received during the last period."},
+	"netOut":    {"cloudpulse_ec2_network_out_bytes", "Bytes sent during the last period."},
+	"inService": {"cloudpulse_asg_in_service_instances", "Instances in service in the Auto Scaling group."},
+}
+
+// ec2UsagePromHandler serves the latest ec2-usage values in the Prometheus text
+// exposition format. It accepts the same query parameters as ec2UsageHandler
+// (except mode=series) and shares its cache.
+func ec2UsagePromHandler(w http.ResponseWriter, r *http.Request) {
+	if cwClient == nil {
+		http.Error(w, "AWS client not initialized", http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Query().Get("mode") == "series" {
+		http.Error(w, "mode=series is not supported in Prometheus format", http.StatusBadRequest)
+		return
+	}
+
+	var result map[string]interface{}
+	cacheKey := ec2CacheKey(r)
+	if cached, ok := responseCache.Get(cacheKey); ok {
+		result = cached.(map[string]interface{})
+	} else {
+		var err error
+		result, err = fetchEC2Usage(r.Context(), r)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		responseCache.Set(cacheKey, result, ec2CacheTTL())
+	}
+
+	labels := fmt.Sprintf(`instance_id="%s"`, promLabelValue(result["InstanceID"]))
+	if asg, ok := result["AutoScalingGroup"]; ok {
+		labels = fmt.Sprintf(`asg="%s"`, promLabelValue(asg))
+	}
+	if account, ok := result["AccountId"]; ok {
+		labels += fmt.Sprintf(`,account_id="%s"`, promLabelValue(account))
+	}
+
+	// Group samples by metric so each family gets one HELP/TYPE header.
+	samples := make(map[string][]string)
+	for key, value := range result {
+		v, ok := value.(float64)
+		if !ok {
+			continue // "N/A", timestamps, units and display strings
+		}
+		base, stat, _ := strings.Cut(key, "_")
+		if _, known := promMetrics[base]; !known || strings.HasSuffix(key, "_bps") {
+			continue // rates are derivable from the byte counters with rate()
+		}
+		sampleLabels := labels
+		if stat != "" {
+			sampleLabels += fmt.Sprintf(`,stat="%s"`, promLabelValue(stat))
+		}
+		samples[base] = append(samples[base], fmt.Sprintf("%s{%s} %s", promMetrics[base][0], sampleLabels, strconv.FormatFloat(v, 'g', -1, 64)))
+	}
+
+	ids := make([]string, 0, len(samples))
+	for id := range samples {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var b strings.Builder
+	for _, id := range ids {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", promMetrics[id][0], promMetrics[id][1], promMetrics[id][0])
+		sort.Strings(samples[id])
+		for _, line := range samples[id] {
+			b.WriteString(line + "\n")
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+// promLabelEscaper escapes a label value for the Prometheus text format, which only
+// allows \\, \" and \n escapes inside the quotes.
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabelValue formats v as a label value to go between double quotes. Values such
+// as ?asg= come from the request, and one stray quote would break the whole scrape.
+func promLabelValue(v interface{}) string {
+	return promLabelEscaper.Replace(fmt.Sprint(v))
+}
+
+// cwDashboardsHandler lists the account's CloudWatch dashboards, optionally filtered by ?prefix=.
+func cwDashboardsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if cwClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "AWS client not initialized")
+		return
+	}
+
+	type DashboardInfo struct {
+		Name         string `json:"name"`
+		Arn          string `json:"arn"`
+		LastModified string `json:"lastModified,omitempty"`
+		Size         int64  `json:"size"`
+	}
+
+	input := &cloudwatch.ListDashboardsInput{}
+	if prefix := r.URL.Query().Get("prefix"); prefix != "" {
+		input.DashboardNamePrefix = aws.String(prefix)
+	}
+
+	dashboards := make([]DashboardInfo, 0)
+	for {
+		resp, err := cwClient.ListDashboards(r.Context(), input)
+		if err != nil {
+			log.Printf("Error listing CloudWatch dashboards: %v", err)
+			writeUpstreamError(w, "Error listing CloudWatch dashboards", err)
+			return
+		}
+		for _, entry := range resp.DashboardEntries {
+			info := DashboardInfo{
+				Name: aws.ToString(entry.DashboardName),
+				Arn:  aws.ToString(entry.DashboardArn),
+				Size: aws.ToInt64(entry.Size),
+			}
+			if entry.LastModified != nil {
+				info.LastModified = entry.LastModified.Format(time.RFC3339)
+			}
+			dashboards = append(dashboards, info)
+		}
+		if resp.NextToken == nil {
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+
+	writeJSON(w, r, dashboards)
+}
+
+// cwDashboardHandler returns one dashboard (?name=) with its body and a summary of its widgets.
+func cwDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if cwClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "AWS client not initialized")
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, "Missing 'name' query parameter")
+		return
+	}
+
+	resp, err := cwClient.GetDashboard(r.Context(), &cloudwatch.GetDashboardInput{DashboardName: aws.String(name)})
+	if err != nil {
+		var notFound *types.DashboardNotFoundError
+		if errors.As(err, &notFound) {
+			writeErrorJSON(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("Dashboard '%s' not found", name))
+			return
+		}
+		log.Printf("Error getting CloudWatch dashboard %s: %v", name, err)
+		writeUpstreamError(w, "Error getting CloudWatch dashboard", err)
+		return
+	}
+
+	// The body is a JSON document; pass it through as-is and pull out widget titles for the summary.
+	body := json.RawMessage(aws.ToString(resp.DashboardBody))
+	var parsed struct {
+		Widgets []struct {
+			Type       string `json:"type"`
+			Properties struct {
+				Title string `json:"title"`
+			} `json:"properties"`
+		} `json:"widgets"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		log.Printf("Could not parse body of dashboard %s: %v", name, err)
+		writeErrorJSON(w, http.StatusBadGateway, codeUpstreamError, "Dashboard body is not valid JSON")
+		return
+	}
+
+	type WidgetSummary struct {
+		Type  string `json:"type"`
+		Title string `json:"title,omitempty"`
+	}
+	widgets := make([]WidgetSummary, 0, len(parsed.Widgets))
+	for _, widget := range parsed.Widgets {
+		widgets = append(widgets, WidgetSummary{Type: widget.Type, Title: widget.Properties.Title})
+	}
+
+	writeJSON(w, r, map[string]interface{}{
+		"name":    aws.ToString(resp.DashboardName),
+		"arn":     aws.ToString(resp.DashboardArn),
+		"widgets": widgets,
+		"body":    body,
+	})
+}
+
+// alignSeries lines up every series in a series-mode result on a shared, sorted
+// list of timestamps: the union of all series' timestamps. It returns those
+// timestamps, the sorted series IDs, and the values indexed by timestamp then ID;
+// a series with no datapoint at a timestamp simply has no entry there.
+func alignSeries(result map[string]interface{}) ([]string, []string, map[string]map[string]float64) {
+	columns := make([]string, 0)
+	values := make(map[string]map[string]float64) // timestamp -> column -> value
+	for id, v := range result {
+		points, ok := v.([]seriesPoint)
+		if !ok {
+			continue
+		}
+		columns = append(columns, id)
+		for _, p := range points {
+			if values[p.Timestamp] == nil {
+				values[p.Timestamp] = make(map[string]float64)
+			}
+			values[p.Timestamp][id] = p.Value
+		}
+	}
+	sort.Strings(columns)
+
+	timestamps := make([]string, 0, len(values))
+	for ts := range values {
+		timestamps = append(timestamps, ts)
+	}
+	sort.Strings(timestamps) // RFC3339 in UTC sorts chronologically
+	return timestamps, columns, values
+}
+
+// columnsFromSeries reshapes a series-mode result for ?shape=columns: a single
+// "timestamps" array plus one array per series with the same length and order.
+// Where a series has no datapoint at a timestamp its entry is null. Non-series
+// fields (InstanceID, units, messages) are kept as they are.
+func columnsFromSeries(result map[string]interface{}) map[string]interface{} {
+	timestamps, columns, values := alignSeries(result)
+
+	shaped := make(map[string]interface{}, len(result)+1)
+	for k, v := range result {
+		if _, isSeries := v.([]seriesPoint); !isSeries {
+			shaped[k] = v
+		}
+	}
+	shaped["timestamps"] = timestamps
+	for _, col := range columns {
+		column := make([]*float64, len(timestamps))
+		for i, ts := range timestamps {
+			if v, ok := values[ts][col]; ok {
+				column[i] = &v
+			}
+		}
+		shaped[col] = column
+	}
+	return shaped
+}
+
+// groupedMetricFields maps the per-metric "<id><suffix>" keys of an ec2-usage result
+// to the key they get inside the metric's object for ?group=bymetric.
+var groupedMetricFields = map[string]string{
+	"_unit":        "unit",
+	"_Timestamp":   "timestamp",
+	"_label":       "label",
+	"_display":     "display",
+	"_smoothed":    "smoothed",
+	"_bps":         "bps",
+	"_bps_display": "bps_display",
+	"_message":     "message",
+}
+
+// groupByMetric reshapes an ec2-usage result for ?group=bymetric: each metric ID
+// becomes one object holding its value (or series in series mode), unit, timestamp,
+// label, threshold, severity, alarms and staleness. Fields that aren't about one metric
+// (InstanceID, period_seconds, messages) stay at the top level.
+func groupByMetric(result map[string]interface{}) map[string]interface{} {
+	labels, _ := result["labels"].(map[string]string)
+	thresholds, _ := result["thresholds"].(map[string]metricThreshold)
+	severity, _ := result["severity"].(map[string]string)
+	alarms, _ := result["alarms"].(map[string][]metricAlarm)
+	stale := result["stale"] == true
+
+	grouped := make(map[string]interface{}, len(result))
+	for k, v := range result {
+		grouped[k] = v
+	}
+	delete(grouped, "labels")
+	delete(grouped, "thresholds")
+	delete(grouped, "severity")
+	delete(grouped, "alarms")
+
+	for id, name := range labels {
+		metric := map[string]interface{}{"name": name, "stale": stale}
+		switch v := result[id].(type) {
+		case []seriesPoint:
+			metric["series"] = v
+		case float64:
+			metric["value"] = v
+		default:
+			metric["value"] = nil // "N/A": no datapoints in the window
+		}
+		for suffix, key := range groupedMetricFields {
+			if v, ok := result[id+suffix]; ok {
+				metric[key] = v
+				delete(grouped, id+suffix)
+			}
+		}
+		if threshold, ok := thresholds[id]; ok {
+			metric["threshold"] = threshold
+		}
+		if s, ok := severity[id]; ok {
+			metric["severity"] = s
+		}
+		if a, ok := alarms[id]; ok {
+			metric["alarms"] = a
+		}
+		grouped[id] = metric
+	}
+	return grouped
+}
+
+// writeSeriesCSV writes the series in a series-mode result as CSV: a timestamp
+// column plus one column per series, with rows aligned by alignSeries. Cells are
+// left empty where a series has no datapoint at that time.
+func writeSeriesCSV(w http.ResponseWriter, r *http.Request, result map[string]interface{}) {
+	timestamps, columns, values := alignSeries(result)
+
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Write(append([]string{"timestamp"}, columns...))
+	for _, ts := range timestamps {
+		row := make([]string, 0, len(columns)+1)
+		row = append(row, ts)
+		for _, col := range columns {
+			if v, ok := values[ts][col]; ok {
+				row = append(row, strconv.FormatFloat(v, 'f', -1, 64))
+			} else {
+				row = append(row, "")
+			}
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Printf("Error encoding CSV response for %s %s: %v", r.Method, r.URL.Path, err)
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "Error encoding response")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="ec2-usage-%s.csv"`, time.Now().UTC().Format("20060102-150405")))
+	buf.WriteTo(w)
+}
+
+// allowedNamespaces is the set of CloudWatch namespaces /api/custom-metric may read,
+// from the comma-separated ALLOWED_NAMESPACES. Empty disables the endpoint, so
+// CloudPulse never becomes an unrestricted CloudWatch proxy.
+var allowedNamespaces = namespacesFromEnv()
+
+// namespacesFromEnv parses ALLOWED_NAMESPACES into a set.
+func namespacesFromEnv() map[string]bool {
+	allowed := make(map[string]bool)
+	for _, ns := range strings.Split(os.Getenv("ALLOWED_NAMESPACES"), ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			allowed[ns] = true
+		}
+	}
+	return allowed
+}
+
+// maxCustomDimensions is CloudWatch's limit on dimensions per metric.
+const maxCustomDimensions = 30
+
+// customMetricHandler reads any metric from an allowed namespace:
+// ?namespace=MyApp&metric=Latency&dim=Service:api&stat=p99, plus the usual
+// ?range=, ?period= and ?mode=series. Custom namespaces also accept the sub-minute
+// periods of high-resolution metrics. Free-capacity metrics such as FreeStorageSpace
+// also get "custom_percent_used" when the total is known (see addPercentUsed).
+func customMetricHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if cwClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "AWS client not initialized")
+		return
+	}
+	if len(allowedNamespaces) == 0 {
+		writeErrorJSON(w, http.StatusForbidden, codeForbidden, "Custom metrics are disabled; set ALLOWED_NAMESPACES to enable them")
+		return
+	}
+
+	q := r.URL.Query()
+	namespace := q.Get("namespace")
+	if !allowedNamespaces[namespace] {
+		writeErrorJSON(w, http.StatusForbidden, codeForbidden, fmt.Sprintf("Namespace '%s' is not in ALLOWED_NAMESPACES", namespace))
+		return
+	}
+	metricName := q.Get("metric")
+	if metricName == "" {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, "Missing 'metric' query parameter")
+		return
+	}
+	stat := q.Get("stat")
+	if stat == "" {
+		stat = "Average"
+	}
+	if !validStats[stat] && !isPercentileStat(stat) {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("invalid 'stat' parameter '%s'", stat))
+		return
+	}
+
+	dims := q["dim"]
+	if len(dims) > maxCustomDimensions {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("at most %d 'dim' parameters are allowed", maxCustomDimensions))
+		return
+	}
+	dimensions := make([]types.Dimension, 0, len(dims))
+	for _, dim := range dims {
+		name, value, found := strings.Cut(dim, ":")
+		if !found || name == "" || value == "" {
+			writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("invalid 'dim' parameter '%s', expected Name:Value", dim))
+			return
+		}
+		dimensions = append(dimensions, types.Dimension{Name: aws.String(name), Value: aws.String(value)})
+	}
+
+	mode := q.Get("mode")
+	if mode != "" && mode != "latest" && mode != "series" {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid 'mode' parameter '%s', expected 'latest' or 'series'", mode))
+		return
+	}
+	startTime, endTime, period, err := parseWindowAndPeriodRes(r, isCustomNamespace(namespace))
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	if err := checkCardinality(1, startTime, endTime, period); err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	cacheKey := cacheKeyFor("custom-metric:"+namespace, r)
+	if cached, ok := responseCache.Get(cacheKey); ok {
+		writeJSON(w, r, cached)
+		return
+	}
+
+	resp, err := getAllMetricData(r.Context(), &cloudwatch.GetMetricDataInput{
+		StartTime: &startTime,
+		EndTime:   &endTime,
+		MetricDataQueries: []types.MetricDataQuery{{
+			Id: aws.String("custom"),
+			MetricStat: &types.MetricStat{
+				Metric: &types.Metric{
+					Namespace:  aws.String(namespace),
+					MetricName: aws.String(metricName),
+					Dimensions: dimensions,
+				},
+				Period: aws.Int32(period),
+				Stat:   aws.String(stat),
+			},
+			ReturnData: aws.Bool(true),
+		}},
+		ScanBy: types.ScanByTimestampDescending,
+	})
+	if err != nil {
+		log.Printf("Error getting custom metric %s/%s: %v", namespace, metricName, err)
+		writeUpstreamError(w, "Error getting CloudWatch data", err)
+		return
+	}
+
+	result := map[string]interface{}{
+		"namespace": namespace,
+		"metric":    metricName,
+		"stat":      stat,
+		"period":    period,
+	}
+	for _, mdr := range resp.MetricDataResults {
+		if mode == "series" {
+			result["custom"] = seriesFromResult(mdr)
+			result["custom_label"] = metricLabel(mdr)
+			continue
+		}
+		addLatestValues(result, []types.MetricDataResult{mdr})
+	}
+	addPercentUsed(r.Context(), result, "custom", metricName, dimensions)
+
+	// ?diagnose=true explains an empty result by listing the dimension sets the metric
+	// is actually published with. It costs an extra ListMetrics call, so it is opt-in.
+	if q.Get("diagnose") == "true" && !hasValues(resp.MetricDataResults, "custom") {
+		suggestions, err := suggestDimensions(r.Context(), namespace, metricName)
+		if err != nil {
+			log.Printf("Error listing dimensions for %s/%s: %v", namespace, metricName, err)
+		} else {
+			result["suggested_dimensions"] = suggestions
+		}
+	}
+	responseCache.Set(cacheKey, result, customMetricCacheTTL(namespace))
+
+	writeJSON(w, r, result)
+}
+
+// maxDimensionSuggestions caps how many dimension sets ?diagnose=true returns.
+const maxDimensionSuggestions = 20
+
+// suggestDimensions lists the dimension combinations namespace/metricName is published
+// with, each as "Name:Value" strings that can be passed straight back as ?dim=.
+func suggestDimensions(ctx context.Context, namespace, metricName string) ([][]string, error) {
+	suggestions := make([][]string, 0)
+	input := &cloudwatch.ListMetricsInput{
+		Namespace:  aws.String(namespace),
+		MetricName: aws.String(metricName),
+	}
+	for len(suggestions) < maxDimensionSuggestions {
+		resp, err := cwClient.ListMetrics(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, metric := range resp.Metrics {
+			if len(suggestions) == maxDimensionSuggestions {
+				break
+			}
+			dims := make([]string, 0, len(metric.Dimensions))
+			for _, dim := range metric.Dimensions {
+				dims = append(dims, aws.ToString(dim.Name)+":"+aws.ToString(dim.Value))
+			}
+			suggestions = append(suggestions, dims)
+		}
+		if resp.NextToken == nil {
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+	return suggestions, nil
+}
+
+// ecsUsageHandler fetches service-level CPU, memory and task count from Container
+// Insights (?cluster=X&service=Y). It accepts the same ?range=, ?period= and
+// ?mode=series parameters as ec2-usage. Container Insights must be enabled on the cluster.
+func ecsUsageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if cwClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "AWS client not initialized")
+		return
+	}
+
+	cluster := r.URL.Query().Get("cluster")
+	service := r.URL.Query().Get("service")
+	if cluster == "" || service == "" {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, "Both 'cluster' and 'service' query parameters are required")
+		return
+	}
+	mode := r.URL.Query().Get("mode")
+	if mode != "" && mode != "latest" && mode != "series" {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid 'mode' parameter '%s', expected 'latest' or 'series'", mode))
+		return
+	}
+	startTime, endTime, period, err := parseWindowAndPeriod(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	dimensions := []types.Dimension{
+		{Name: aws.String("ClusterName"), Value: aws.String(cluster)},
+		{Name: aws.String("ServiceName"), Value: aws.String(service)},
+	}
+	ecsQuery := func(id, metricName, stat string) types.MetricDataQuery {
+		return types.MetricDataQuery{
+			Id: aws.String(id),
+			MetricStat: &types.MetricStat{
+				Metric: &types.Metric{
+					Namespace:  aws.String("ECS/ContainerInsights"),
+					MetricName: aws.String(metricName),
+					Dimensions: dimensions,
+				},
+				Period: aws.Int32(period),
+				Stat:   aws.String(stat),
+			},
+			ReturnData: aws.Bool(true),
+		}
+	}
+
+	resp, err := getAllMetricData(r.Context(), &cloudwatch.GetMetricDataInput{
+		StartTime: &startTime,
+		EndTime:   &endTime,
+		MetricDataQueries: []types.MetricDataQuery{
+			ecsQuery("cpuUtilized", "CpuUtilized", "Average"),       // CPU units (1024 = 1 vCPU)
+			ecsQuery("memoryUtilized", "MemoryUtilized", "Average"), // MiB
+			ecsQuery("runningTasks", "RunningTaskCount", "Average"),
+		},
+		ScanBy: types.ScanByTimestampDescending,
+	})
+	if err != nil {
+		log.Printf("Error getting ECS CloudWatch data: %v", err)
+		writeUpstreamError(w, "Error getting ECS CloudWatch data", err)
+		return
+	}
+
+	result := map[string]interface{}{
+		"ClusterName": cluster,
+		"ServiceName": service,
+	}
+	if mode == "series" {
+		for _, mdr := range resp.MetricDataResults {
+			result[aws.ToString(mdr.Id)] = seriesFromResult(mdr)
+			result[aws.ToString(mdr.Id)+"_label"] = metricLabel(mdr)
+		}
+	} else {
+		addLatestValues(result, resp.MetricDataResults)
+	}
+	if !hasValues(resp.MetricDataResults, "cpuUtilized", "memoryUtilized", "runningTasks") {
+		result["message"] = "No Container Insights data found. Check the cluster and service names and that Container Insights is enabled."
+	}
+
+	writeJSON(w, r, result)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+func TestEC2UsagePromEscapesLabelValues(t *testing.T) {
+	useFakeCloudWatch(t, &fakeCloudWatch{results: []types.MetricDataResult{metricResult("cpu", 12)}})
+
+	w := httptest.NewRecorder()
+	query := url.Values{"asg": {"web\"prod\\blue\nx"}}
+	ec2UsagePromHandler(w, httptest.NewRequest(http.MethodGet, "/api/ec2-usage/prom?"+query.Encode(), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200; body %s", w.Code, w.Body.String())
+	}
+	want := `{asg="web\"prod\\blue\nx"} 12`
+	if !strings.Contains(w.Body.String(), want) {
+		t.Errorf("body does not contain %s:\n%s", want, w.Body.String())
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// --- Collaborator Change Tracking ---
+
+// maxCollaboratorChanges caps the change log kept in the snapshot.
+const maxCollaboratorChanges = 100
+
+// collaboratorChange is one detected access grant or removal.
+type collaboratorChange struct {
+	Login      string    `json:"login"`
+	Change     string    `json:"change"` // "added" or "removed"
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// collaboratorSnapshot is the last seen collaborator list plus a rolling log of
+// changes. It is kept in memory and, with COLLABORATOR_SNAPSHOT_FILE, on disk so
+// the baseline survives restarts.
+type collaboratorSnapshot struct {
+	Repo    string               `json:"repo"` // owner/name the logins belong to
+	TakenAt time.Time            `json:"taken_at"`
+	Logins  []string             `json:"logins"`
+	Changes []collaboratorChange `json:"changes"` // oldest first
+}
+
+var (
+	collaboratorMu       sync.Mutex
+	collaboratorSnap     *collaboratorSnapshot
+	collaboratorSnapRead bool // whether COLLABORATOR_SNAPSHOT_FILE has been loaded
+)
+
+// githubCollaboratorChangesHandler compares the current collaborators with the
+// previous snapshot, records who was added or removed, and stores the new snapshot.
+// The first call only establishes the baseline.
+func githubCollaboratorChangesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if githubClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "GitHub client not initialized")
+		return
+	}
+
+	logins, err := listCollaboratorLogins(r)
+	if err != nil {
+		log.Printf("Error getting GitHub collaborators: %v", err)
+		writeGitHubError(w, "Error getting GitHub collaborators", err)
+		return
+	}
+
+	collaboratorMu.Lock()
+	defer collaboratorMu.Unlock()
+
+	path := os.Getenv("COLLABORATOR_SNAPSHOT_FILE")
+	if !collaboratorSnapRead {
+		collaboratorSnapRead = true
+		if snap, err := readCollaboratorSnapshot(path); err != nil {
+			log.Printf("Ignoring unreadable COLLABORATOR_SNAPSHOT_FILE: %v", err)
+		} else {
+			collaboratorSnap = snap
+		}
+	}
+
+	repo := githubOwner + "/" + githubRepo
+	now := time.Now().UTC()
+	added, removed := []string{}, []string{}
+	next := &collaboratorSnapshot{Repo: repo, TakenAt: now, Logins: logins, Changes: []collaboratorChange{}}
+	result := map[string]interface{}{"checked_at": now.Format(time.RFC3339), "previous_snapshot_at": nil}
+
+	prev := collaboratorSnap
+	if prev != nil && prev.Repo == repo {
+		added, removed = diffLogins(prev.Logins, logins)
+		next.Changes = prev.Changes
+		for _, login := range added {
+			next.Changes = append(next.Changes, collaboratorChange{Login: login, Change: "added", DetectedAt: now})
+		}
+		for _, login := range removed {
+			next.Changes = append(next.Changes, collaboratorChange{Login: login, Change: "removed", DetectedAt: now})
+		}
+		if len(next.Changes) > maxCollaboratorChanges {
+			next.Changes = next.Changes[len(next.Changes)-maxCollaboratorChanges:]
+		}
+		result["previous_snapshot_at"] = prev.TakenAt.Format(time.RFC3339)
+	} else {
+		result["message"] = "No previous snapshot; this call recorded the baseline."
+	}
+	if len(added) > 0 || len(removed) > 0 {
+		log.Printf("GitHub collaborators changed for %s: added %v, removed %v", repo, added, removed)
+	}
+
+	collaboratorSnap = next
+	if err := writeCollaboratorSnapshot(path, next); err != nil {
+		log.Printf("Error saving COLLABORATOR_SNAPSHOT_FILE: %v", err)
+	}
+
+	result["added"] = added
+	result["removed"] = removed
+	result["recent_changes"] = next.Changes
+	writeJSON(w, r, result)
+}
+
+// listCollaboratorLogins returns every collaborator login on the repository, sorted.
+func listCollaboratorLogins(r *http.Request) ([]string, error) {
+	logins := make([]string, 0)
+	opts := &github.ListCollaboratorsOptions{ListOptions: github.ListOptions{PerPage: maxPerPage}}
+	for {
+		ctx, cancel := githubContext(r)
+		var page []*github.User
+		var resp *github.Response
+		err := withRetryBudget(ctx, func() (err error) {
+			page, resp, err = githubClient.Repositories.ListCollaborators(ctx, githubOwner, githubRepo, opts)
+			return err
+		})
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range page {
+			logins = append(logins, safeDeref(user.Login))
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	sort.Strings(logins)
+	return logins, nil
+}
+
+// diffLogins returns the logins in current but not previous, and in previous but not current.
+func diffLogins(previous, current []string) (added, removed []string) {
+	before := make(map[string]bool, len(previous))
+	for _, login := range previous {
+		before[login] = true
+	}
+	now := make(map[string]bool, len(current))
+	added, removed = []string{}, []string{}
+	for _, login := range current {
+		now[login] = true
+		if !before[login] {
+			added = append(added, login)
+		}
+	}
+	for _, login := range previous {
+		if !now[login] {
+			removed = append(removed, login)
+		}
+	}
+	return added, removed
+}
+
+// readCollaboratorSnapshot loads the snapshot at path. A missing file (or no path) is not an error.
+func readCollaboratorSnapshot(path string) (*collaboratorSnapshot, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snap collaboratorSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &snap, nil
+}
+
+// writeCollaboratorSnapshot saves snap to path via a temporary file, so a crash
+// mid-write never leaves a truncated baseline. Without a path it does nothing.
+func writeCollaboratorSnapshot(path string, snap *collaboratorSnapshot) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/google/go-github/v58/github"
+)
+
+// --- Deploy Readiness ---
+
+// defaultDeployCPUThreshold is the CPU percent at or above which a deploy is held back.
+const defaultDeployCPUThreshold = 80.0
+
+// deployCPUWindow is how far back the CPU gate averages.
+const deployCPUWindow = 10 * time.Minute
+
+// deployGate is the outcome of one readiness check.
+type deployGate struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+// deployReadinessHandler answers "is it safe to deploy ?sha= to ?environment= now?"
+// for CI/CD pipelines. It combines four gates: the commit's statuses and checks are
+// green, no CloudWatch alarm is firing, the monitored instance's CPU is below
+// DEPLOY_CPU_THRESHOLD (or ?cpu_threshold=), and the environment's last deployment
+// did not fail. A gate that cannot be evaluated counts as failing, so an outage
+// never turns into a green light.
+func deployReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if githubClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "GitHub client not initialized")
+		return
+	}
+	if cwClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "AWS client not initialized")
+		return
+	}
+
+	q := r.URL.Query()
+	sha := q.Get("sha")
+	if sha == "" {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, "Missing 'sha' query parameter")
+		return
+	}
+	environment := q.Get("environment")
+	if environment == "" {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, "Missing 'environment' query parameter")
+		return
+	}
+	threshold := defaultDeployCPUThreshold
+	if raw := os.Getenv("DEPLOY_CPU_THRESHOLD"); raw != "" {
+		if t, err := strconv.ParseFloat(raw, 64); err == nil && t > 0 {
+			threshold = t
+		} else {
+			log.Printf("Invalid DEPLOY_CPU_THRESHOLD=%q, using %.0f%%", raw, defaultDeployCPUThreshold)
+		}
+	}
+	if raw := q.Get("cpu_threshold"); raw != "" {
+		t, err := strconv.ParseFloat(raw, 64)
+		if err != nil || t <= 0 {
+			writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("invalid 'cpu_threshold' parameter '%s', expected a positive percentage", raw))
+			return
+		}
+		threshold = t
+	}
+	instance, err := requestInstanceID(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	gates := []deployGate{
+		checksGate(r, sha),
+		alarmsGate(r),
+		cpuGate(r, instance, threshold),
+		deploymentGate(r, environment),
+	}
+	failing := make([]string, 0)
+	for _, gate := range gates {
+		if !gate.Passed {
+			failing = append(failing, gate.Name)
+		}
+	}
+
+	writeJSON(w, r, map[string]interface{}{
+		"sha":           sha,
+		"environment":   environment,
+		"ready":         len(failing) == 0,
+		"failing_gates": failing,
+		"gates":         gates,
+	})
+}
+
+// checksGate passes when the commit's combined verdict is "success".
+func checksGate(r *http.Request, sha string) deployGate {
+	gate := deployGate{Name: "checks"}
+	status, err := fetchCommitStatus(r, sha)
+	switch {
+	case err != nil && (isGitHubNotFound(err) || githubErrorStatus(err) == http.StatusUnprocessableEntity):
+		gate.Detail = fmt.Sprintf("commit '%s' not found", sha)
+	case err != nil:
+		log.Printf("Deploy readiness: error getting commit status for %s: %v", sha, err)
+		gate.Detail = fmt.Sprintf("could not read commit status: %v", err)
+	default:
+		gate.Passed = status.Verdict == "success"
+		gate.Detail = fmt.Sprintf("commit verdict is %s (%d statuses, %d check runs)", status.Verdict, len(status.Statuses), len(status.CheckRuns))
+	}
+	return gate
+}
+
+// alarmsGate passes when no metric or composite alarm is in the ALARM state.
+func alarmsGate(r *http.Request) deployGate {
+	gate := deployGate{Name: "alarms"}
+	firing := make([]string, 0)
+	paginator := cloudwatch.NewDescribeAlarmsPaginator(cwClient, &cloudwatch.DescribeAlarmsInput{
+		StateValue: types.StateValueAlarm,
+		AlarmTypes: []types.AlarmType{types.AlarmTypeMetricAlarm, types.AlarmTypeCompositeAlarm},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(r.Context())
+		if err != nil {
+			log.Printf("Deploy readiness: error describing CloudWatch alarms: %v", err)
+			gate.Detail = fmt.Sprintf("could not read CloudWatch alarms: %v", err)
+			return gate
+		}
+		for _, alarm := range page.MetricAlarms {
+			firing = append(firing, safeDeref(alarm.AlarmName))
+		}
+		for _, alarm := range page.CompositeAlarms {
+			firing = append(firing, safeDeref(alarm.AlarmName))
+		}
+	}
+	gate.Passed = len(firing) == 0
+	if gate.Passed {
+		gate.Detail = "no alarms firing"
+	} else {
+		gate.Detail = fmt.Sprintf("%d alarm(s) firing: %v", len(firing), firing)
+	}
+	return gate
+}
+
+// cpuGate passes when the instance's average CPU over deployCPUWindow is below threshold.
+func cpuGate(r *http.Request, instance string, threshold float64) deployGate {
+	gate := deployGate{Name: "cpu"}
+	if instance == "" {
+		gate.Detail = "EC2 instance ID not determined; pass 'instance' explicitly"
+		return gate
+	}
+	end := time.Now().Truncate(time.Minute)
+	avg, err := averageCPU(r.Context(), instance, end.Add(-deployCPUWindow), end)
+	switch {
+	case err != nil:
+		log.Printf("Deploy readiness: error getting CPU for %s: %v", instance, err)
+		gate.Detail = fmt.Sprintf("could not read CPU for %s: %v", publicInstanceID(instance), err)
+	case avg == nil:
+		gate.Detail = fmt.Sprintf("no CPU datapoints for %s in the last %s", publicInstanceID(instance), deployCPUWindow)
+	default:
+		gate.Passed = *avg < threshold
+		gate.Detail = fmt.Sprintf("average CPU on %s over the last %s is %.1f%% (threshold %.1f%%)", publicInstanceID(instance), deployCPUWindow, *avg, threshold)
+	}
+	return gate
+}
+
+// deploymentGate passes unless the environment's most recent deployment ended in
+// "failure" or "error". An environment that was never deployed passes.
+func deploymentGate(r *http.Request, environment string) deployGate {
+	gate := deployGate{Name: "last_deployment"}
+	ctx, cancel := githubContext(r)
+	defer cancel()
+
+	var deployments []*github.Deployment
+	err := withRetryBudget(ctx, func() (err error) {
+		deployments, _, err = githubClient.Repositories.ListDeployments(ctx, githubOwner, githubRepo, &github.DeploymentsListOptions{
+			Environment: environment,
+			ListOptions: github.ListOptions{PerPage: 1},
+		})
+		return err
+	})
+	if err != nil {
+		log.Printf("Deploy readiness: error listing deployments for %s: %v", environment, err)
+		gate.Detail = fmt.Sprintf("could not list deployments: %v", err)
+		return gate
+	}
+	if len(deployments) == 0 {
+		gate.Passed = true
+		gate.Detail = fmt.Sprintf("no previous deployments to %s", environment)
+		return gate
+	}
+
+	latest := deployments[0]
+	var statuses []*github.DeploymentStatus
+	err = withRetryBudget(ctx, func() (err error) {
+		statuses, _, err = githubClient.Repositories.ListDeploymentStatuses(ctx, githubOwner, githubRepo, latest.GetID(), &github.ListOptions{PerPage: 1})
+		return err
+	})
+	if err != nil {
+		log.Printf("Deploy readiness: error listing statuses of deployment %d: %v", latest.GetID(), err)
+		gate.Detail = fmt.Sprintf("could not read deployment status: %v", err)
+		return gate
+	}
+	state := "unknown"
+	if len(statuses) > 0 {
+		state = safeDeref(statuses[0].State)
+	}
+	gate.Passed = state != "failure" && state != "error"
+	gate.Detail = fmt.Sprintf("last deployment to %s (%s) is %s", environment, shortSHA(safeDeref(latest.SHA)), state)
+	return gate
+}
+
+// shortSHA abbreviates a commit SHA the way GitHub displays it.
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
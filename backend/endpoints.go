@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// --- Endpoint Allow-List ---
+
+// writeEndpoints change state or expose internals, so they are off unless
+// ENABLED_ENDPOINTS lists them. "debug-raw" is ec2-usage's ?debug=raw.
+var writeEndpoints = map[string]bool{
+	"cache/flush":         true,
+	"instance-id/refresh": true,
+	"snapshot":            true,
+	"debug-raw":           true,
+}
+
+// enabledEndpoints is the set of endpoint names from ENABLED_ENDPOINTS, or nil when
+// it is unset and every read endpoint is enabled.
+var enabledEndpoints = enabledEndpointsFromEnv()
+
+// registeredEndpoints records every name passed to handleAPI, to catch typos in
+// ENABLED_ENDPOINTS.
+var registeredEndpoints = map[string]bool{"debug-raw": true}
+
+// enabledEndpointsFromEnv parses ENABLED_ENDPOINTS, a comma-separated list of
+// endpoint names as they appear after /api/, e.g. "ec2-usage,github-users,cache/flush".
+func enabledEndpointsFromEnv() map[string]bool {
+	raw := os.Getenv("ENABLED_ENDPOINTS")
+	if raw == "" {
+		return nil
+	}
+	enabled := make(map[string]bool)
+	for _, name := range strings.Split(raw, ",") {
+		if name = strings.Trim(strings.TrimSpace(name), "/"); name != "" {
+			enabled[strings.TrimPrefix(name, "api/")] = true
+		}
+	}
+	return enabled
+}
+
+// endpointEnabled reports whether the endpoint called name is served.
+func endpointEnabled(name string) bool {
+	if enabledEndpoints == nil {
+		return !writeEndpoints[name]
+	}
+	return enabledEndpoints[name]
+}
+
+// handleAPI registers handler at basePath+"/api/"+name if the endpoint is enabled.
+// A disabled endpoint gets a plain 404, so it looks like one that doesn't exist
+// rather than falling through to the frontend.
+func handleAPI(name string, handler http.HandlerFunc) {
+	registeredEndpoints[name] = true
+	if !endpointEnabled(name) {
+		http.HandleFunc(basePath+"/api/"+name, endpointNotFoundHandler)
+		return
+	}
+	http.HandleFunc(basePath+"/api/"+name, handler)
+}
+
+// endpointNotFoundHandler answers requests for disabled endpoints.
+func endpointNotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeErrorJSON(w, http.StatusNotFound, codeNotFound, "Not found")
+}
+
+// logEndpointConfig logs which endpoints are disabled and warns about
+// ENABLED_ENDPOINTS names that match no endpoint. Call it after every handleAPI.
+func logEndpointConfig() {
+	disabled := make([]string, 0)
+	for name := range registeredEndpoints {
+		if !endpointEnabled(name) {
+			disabled = append(disabled, name)
+		}
+	}
+	sort.Strings(disabled)
+	if len(disabled) > 0 {
+		log.Printf("Disabled endpoints (see ENABLED_ENDPOINTS): %s", strings.Join(disabled, ", "))
+	}
+	for name := range enabledEndpoints {
+		if !registeredEndpoints[name] {
+			log.Printf("Ignoring unknown ENABLED_ENDPOINTS entry %q", name)
+		}
+	}
+}
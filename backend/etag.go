@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// --- GitHub Conditional Requests ---
+
+// maxETagEntries bounds the conditional request cache; it is cleared when full.
+const maxETagEntries = 500
+
+// etagEntry is the last 200 response seen for a URL.
+type etagEntry struct {
+	etag   string
+	header http.Header
+	body   []byte
+}
+
+// etagTransport makes GitHub GETs conditional: it remembers each URL's ETag and
+// body, sends If-None-Match on the next request, and turns a 304 back into the
+// cached 200 so go-github and the handlers see an ordinary response. GitHub does
+// not count 304s against the rate limit, which matters for polled lists such as
+// collaborators that rarely change.
+type etagTransport struct {
+	base    http.RoundTripper
+	mu      sync.Mutex
+	entries map[string]etagEntry
+}
+
+// githubETags is the conditional request cache used by the GitHub client.
+var githubETags *etagTransport
+
+// newETagTransport wraps base with a conditional request cache.
+func newETagTransport(base http.RoundTripper) *etagTransport {
+	return &etagTransport{base: base, entries: make(map[string]etagEntry)}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *etagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.base.RoundTrip(req)
+	}
+	key := req.URL.String()
+
+	t.mu.Lock()
+	entry, cached := t.entries[key]
+	t.mu.Unlock()
+	if cached {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", entry.etag)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		debugf("GitHub returned 304 for %s; serving the cached body", key)
+		// Keep the 304's headers on top, so rate-limit counters stay current.
+		header := entry.header.Clone()
+		for k, v := range resp.Header {
+			header[k] = v
+		}
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(entry.body)),
+			ContentLength: int64(len(entry.body)),
+			Request:       req,
+		}, nil
+	}
+
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		return resp, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	t.mu.Lock()
+	if len(t.entries) >= maxETagEntries {
+		t.entries = make(map[string]etagEntry)
+	}
+	t.entries[key] = etagEntry{etag: etag, header: resp.Header.Clone(), body: body}
+	t.mu.Unlock()
+	return resp, nil
+}
+
+// Flush forgets every stored response and returns how many there were.
+func (t *etagTransport) Flush() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := len(t.entries)
+	t.entries = make(map[string]etagEntry)
+	return n
+}
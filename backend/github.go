@@ -0,0 +1,1084 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// --- GitHub Handlers ---
+
+// githubCompareHandler compares two refs (?base=main&head=release) and returns
+// ahead/behind counts plus the changed files, for release-readiness checks.
+func githubCompareHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if githubClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "GitHub client not initialized")
+		return
+	}
+
+	base := r.URL.Query().Get("base")
+	head := r.URL.Query().Get("head")
+	if base == "" || head == "" {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, "Both 'base' and 'head' query parameters are required")
+		return
+	}
+
+	ctx, cancel := githubContext(r)
+	defer cancel()
+
+	var comparison *github.CommitsComparison
+	err := withRetryBudget(ctx, func() (err error) {
+		comparison, _, err = githubClient.Repositories.CompareCommits(ctx, githubOwner, githubRepo, base, head, nil)
+		return err
+	})
+	if err != nil {
+		if isGitHubNotFound(err) {
+			writeErrorJSON(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("Ref not found: base '%s' or head '%s' does not exist", base, head))
+			return
+		}
+		log.Printf("Error comparing GitHub refs %s...%s: %v", base, head, err)
+		writeGitHubError(w, "Error comparing GitHub refs", err)
+		return
+	}
+
+	type ChangedFile struct {
+		Filename  string `json:"filename"`
+		Status    string `json:"status"`
+		Additions int    `json:"additions"`
+		Deletions int    `json:"deletions"`
+		Changes   int    `json:"changes"`
+	}
+
+	// Identical refs come back with status "identical" and no files; keep the
+	// list as an empty array rather than null so consumers don't need a special case.
+	files := make([]ChangedFile, 0, len(comparison.Files))
+	for _, f := range comparison.Files {
+		files = append(files, ChangedFile{
+			Filename:  safeDeref(f.Filename),
+			Status:    safeDeref(f.Status),
+			Additions: safeDerefInt(f.Additions),
+			Deletions: safeDerefInt(f.Deletions),
+			Changes:   safeDerefInt(f.Changes),
+		})
+	}
+
+	result := map[string]interface{}{
+		"base":         base,
+		"head":         head,
+		"status":       safeDeref(comparison.Status),
+		"aheadBy":      safeDerefInt(comparison.AheadBy),
+		"behindBy":     safeDerefInt(comparison.BehindBy),
+		"totalCommits": safeDerefInt(comparison.TotalCommits),
+		"identical":    safeDeref(comparison.Status) == "identical",
+		"files":        files,
+	}
+
+	writeJSON(w, r, result)
+}
+
+// CommitPerson is a commit's git author or committer, with the GitHub login when
+// the email is linked to an account.
+type CommitPerson struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Login string `json:"login,omitempty"`
+	Date  string `json:"date,omitempty"`
+}
+
+// commitPerson flattens git identity a and GitHub account u, either of which may be nil.
+func commitPerson(a *github.CommitAuthor, u *github.User) CommitPerson {
+	person := CommitPerson{Login: u.GetLogin()}
+	if a != nil {
+		person.Name = safeDeref(a.Name)
+		person.Email = safeDeref(a.Email)
+		if a.Date != nil {
+			person.Date = a.Date.Format(time.RFC3339)
+		}
+	}
+	return person
+}
+
+// githubCommitHandler returns the detail of a single commit (?sha=): message,
+// author and committer, line stats and the changed files.
+func githubCommitHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if githubClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "GitHub client not initialized")
+		return
+	}
+
+	sha := r.URL.Query().Get("sha")
+	if sha == "" {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, "Missing 'sha' query parameter")
+		return
+	}
+
+	ctx, cancel := githubContext(r)
+	defer cancel()
+
+	var commit *github.RepositoryCommit
+	err := withRetryBudget(ctx, func() (err error) {
+		commit, _, err = githubClient.Repositories.GetCommit(ctx, githubOwner, githubRepo, sha, nil)
+		return err
+	})
+	if err != nil {
+		// GitHub answers 422 rather than 404 for a well-formed SHA that doesn't exist.
+		if status := githubErrorStatus(err); status == http.StatusNotFound || status == http.StatusUnprocessableEntity {
+			writeErrorJSON(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("Commit '%s' not found", sha))
+			return
+		}
+		log.Printf("Error getting GitHub commit %s: %v", sha, err)
+		writeGitHubError(w, "Error getting GitHub commit", err)
+		return
+	}
+
+	type CommitFile struct {
+		Filename         string `json:"filename"`
+		Status           string `json:"status"`
+		Additions        int    `json:"additions"`
+		Deletions        int    `json:"deletions"`
+		Changes          int    `json:"changes"`
+		PreviousFilename string `json:"previous_filename,omitempty"` // set for renames
+	}
+	files := make([]CommitFile, 0, len(commit.Files))
+	for _, f := range commit.Files {
+		files = append(files, CommitFile{
+			Filename:         safeDeref(f.Filename),
+			Status:           safeDeref(f.Status),
+			Additions:        safeDerefInt(f.Additions),
+			Deletions:        safeDerefInt(f.Deletions),
+			Changes:          safeDerefInt(f.Changes),
+			PreviousFilename: safeDeref(f.PreviousFilename),
+		})
+	}
+
+	var message string
+	var author, committer CommitPerson
+	if c := commit.Commit; c != nil {
+		message = safeDeref(c.Message)
+		author = commitPerson(c.Author, commit.Author)
+		committer = commitPerson(c.Committer, commit.Committer)
+	}
+	stats := map[string]int{"additions": 0, "deletions": 0, "total": 0}
+	if commit.Stats != nil {
+		stats["additions"] = safeDerefInt(commit.Stats.Additions)
+		stats["deletions"] = safeDerefInt(commit.Stats.Deletions)
+		stats["total"] = safeDerefInt(commit.Stats.Total)
+	}
+
+	writeJSON(w, r, map[string]interface{}{
+		"sha":       safeDeref(commit.SHA),
+		"message":   message,
+		"author":    author,
+		"committer": committer,
+		"stats":     stats,
+		"files":     files,
+		"html_url":  safeDeref(commit.HTMLURL),
+	})
+}
+
+// stargazersCacheTTL is long because fully paginating stargazers is slow for popular repos.
+const stargazersCacheTTL = 6 * time.Hour
+
+// starWeek is one week in the cumulative stargazers timeline.
+type starWeek struct {
+	Week       string `json:"week"`  // Monday (UTC) starting the week, YYYY-MM-DD
+	Stars      int    `json:"stars"` // stars added during the week
+	Cumulative int    `json:"cumulative"`
+}
+
+// githubStargazersTimelineHandler returns cumulative stars per week, oldest first.
+func githubStargazersTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if githubClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "GitHub client not initialized")
+		return
+	}
+
+	cacheKey := "stargazers-timeline:" + githubOwner + "/" + githubRepo
+	if cached, ok := responseCache.Get(cacheKey); ok {
+		writeJSON(w, r, cached)
+		return
+	}
+
+	// ListStargazers requests the star+json media type, which includes starred_at.
+	weekly := make(map[string]int)
+	total := 0
+	// Always use the largest page size here to minimize round trips; each page gets its own timeout.
+	opts := &github.ListOptions{PerPage: maxPerPage}
+	for {
+		ctx, cancel := githubContext(r)
+		var stargazers []*github.Stargazer
+		var resp *github.Response
+		err := withRetryBudget(ctx, func() (err error) {
+			stargazers, resp, err = githubClient.Activity.ListStargazers(ctx, githubOwner, githubRepo, opts)
+			return err
+		})
+		cancel()
+		if err != nil {
+			log.Printf("Error getting GitHub stargazers: %v", err)
+			writeGitHubError(w, "Error getting GitHub stargazers", err)
+			return
+		}
+		for _, sg := range stargazers {
+			if sg.StarredAt == nil {
+				continue
+			}
+			weekly[weekStart(sg.StarredAt.Time)]++
+			total++
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	weeks := make([]string, 0, len(weekly))
+	for week := range weekly {
+		weeks = append(weeks, week)
+	}
+	sort.Strings(weeks)
+
+	timeline := make([]starWeek, 0, len(weeks))
+	cumulative := 0
+	for _, week := range weeks {
+		cumulative += weekly[week]
+		timeline = append(timeline, starWeek{Week: week, Stars: weekly[week], Cumulative: cumulative})
+	}
+
+	result := map[string]interface{}{
+		"totalStars":  total,
+		"timeline":    timeline,
+		"generatedAt": time.Now().UTC().Format(time.RFC3339),
+	}
+	responseCache.Set(cacheKey, result, cacheTTL("stargazers-timeline", stargazersCacheTTL))
+
+	writeJSON(w, r, result)
+}
+
+// weekStart returns the Monday (UTC) of t's week as YYYY-MM-DD.
+func weekStart(t time.Time) string {
+	t = t.UTC()
+	offset := (int(t.Weekday()) + 6) % 7 // days since Monday
+	monday := time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, time.UTC)
+	return monday.Format("2006-01-02")
+}
+
+// ReleaseInfo is the subset of a GitHub release shown in the "what's deployed" view.
+type ReleaseInfo struct {
+	TagName     string `json:"tag_name"`
+	Name        string `json:"name"`
+	Prerelease  bool   `json:"prerelease"`
+	Draft       bool   `json:"draft"`
+	PublishedAt string `json:"published_at,omitempty"`
+	HTMLURL     string `json:"html_url"`
+	Body        string `json:"body"`
+}
+
+// releaseInfo flattens a release, truncating the notes to maxBody runes when maxBody > 0.
+func releaseInfo(rel *github.RepositoryRelease, maxBody int) ReleaseInfo {
+	info := ReleaseInfo{
+		TagName:    safeDeref(rel.TagName),
+		Name:       safeDeref(rel.Name),
+		Prerelease: rel.GetPrerelease(),
+		Draft:      rel.GetDraft(),
+		HTMLURL:    safeDeref(rel.HTMLURL),
+		Body:       safeDeref(rel.Body),
+	}
+	if rel.PublishedAt != nil {
+		info.PublishedAt = rel.PublishedAt.Format(time.RFC3339)
+	}
+	if body := []rune(info.Body); maxBody > 0 && len(body) > maxBody {
+		info.Body = string(body[:maxBody]) + "…"
+	}
+	return info
+}
+
+// githubReleasesHandler lists releases, or just the latest one with ?latest=true.
+// ?truncate=N shortens release notes to N characters.
+func githubReleasesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if githubClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "GitHub client not initialized")
+		return
+	}
+
+	maxBody := 0
+	if raw := r.URL.Query().Get("truncate"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("invalid 'truncate' parameter '%s', expected a positive integer", raw))
+			return
+		}
+		maxBody = n
+	}
+
+	ctx, cancel := githubContext(r)
+	defer cancel()
+
+	if r.URL.Query().Get("latest") == "true" {
+		var release *github.RepositoryRelease
+		err := withRetryBudget(ctx, func() (err error) {
+			release, _, err = githubClient.Repositories.GetLatestRelease(ctx, githubOwner, githubRepo)
+			return err
+		})
+		if err != nil {
+			if isGitHubNotFound(err) {
+				writeErrorJSON(w, http.StatusNotFound, codeNotFound, "No published releases found for this repository")
+				return
+			}
+			log.Printf("Error getting latest GitHub release: %v", err)
+			writeGitHubError(w, "Error getting latest GitHub release", err)
+			return
+		}
+		writeJSON(w, r, releaseInfo(release, maxBody))
+		return
+	}
+
+	listOpts, err := parsePagination(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	var releases []*github.RepositoryRelease
+	var ghResp *github.Response
+	err = withRetryBudget(ctx, func() (err error) {
+		releases, ghResp, err = githubClient.Repositories.ListReleases(ctx, githubOwner, githubRepo, &listOpts)
+		return err
+	})
+	if err != nil {
+		log.Printf("Error getting GitHub releases: %v", err)
+		writeGitHubError(w, "Error getting GitHub releases", err)
+		return
+	}
+	if len(releases) == 0 && listOpts.Page == 1 {
+		writeErrorJSON(w, http.StatusNotFound, codeNotFound, "No releases found for this repository")
+		return
+	}
+
+	infos := make([]ReleaseInfo, 0, len(releases))
+	for _, rel := range releases {
+		infos = append(infos, releaseInfo(rel, maxBody))
+	}
+
+	setPaginationHeaders(w, listOpts, ghResp)
+	writeJSON(w, r, infos)
+}
+
+// failedConclusions are check run conclusions that block a deploy.
+var failedConclusions = map[string]bool{
+	"failure":         true,
+	"timed_out":       true,
+	"cancelled":       true,
+	"action_required": true,
+	"startup_failure": true,
+}
+
+// StatusContext is one legacy commit status.
+type StatusContext struct {
+	Context     string `json:"context"`
+	State       string `json:"state"`
+	Description string `json:"description,omitempty"`
+	TargetURL   string `json:"target_url,omitempty"`
+}
+
+// CheckRunInfo is one Checks API run on a commit.
+type CheckRunInfo struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Conclusion string `json:"conclusion,omitempty"`
+	HTMLURL    string `json:"html_url,omitempty"`
+}
+
+// commitStatus is the combined view of a commit's statuses and check runs.
+type commitStatus struct {
+	SHA       string          `json:"sha"`
+	Verdict   string          `json:"verdict"`
+	Statuses  []StatusContext `json:"statuses"`
+	CheckRuns []CheckRunInfo  `json:"checkRuns"`
+}
+
+// githubCommitStatusHandler combines the legacy commit statuses and the Checks API
+// for ?sha= into one deploy verdict: "success", "pending" or "failure".
+// A commit with no statuses or checks at all is reported as "pending".
+func githubCommitStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if githubClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "GitHub client not initialized")
+		return
+	}
+
+	sha := r.URL.Query().Get("sha")
+	if sha == "" {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, "Missing 'sha' query parameter")
+		return
+	}
+
+	status, err := fetchCommitStatus(r, sha)
+	if err != nil {
+		if isGitHubNotFound(err) || githubErrorStatus(err) == http.StatusUnprocessableEntity {
+			writeErrorJSON(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("Commit '%s' not found", sha))
+			return
+		}
+		log.Printf("Error getting GitHub commit status for %s: %v", sha, err)
+		writeGitHubError(w, "Error getting GitHub commit status", err)
+		return
+	}
+	writeJSON(w, r, status)
+}
+
+// fetchCommitStatus reads the combined status and every check run for sha and
+// derives the verdict.
+func fetchCommitStatus(r *http.Request, sha string) (*commitStatus, error) {
+	ctx, cancel := githubContext(r)
+	defer cancel()
+
+	var combined *github.CombinedStatus
+	err := withRetryBudget(ctx, func() (err error) {
+		combined, _, err = githubClient.Repositories.GetCombinedStatus(ctx, githubOwner, githubRepo, sha, &github.ListOptions{PerPage: maxPerPage})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]StatusContext, 0, len(combined.Statuses))
+	for _, st := range combined.Statuses {
+		statuses = append(statuses, StatusContext{
+			Context:     safeDeref(st.Context),
+			State:       safeDeref(st.State),
+			Description: safeDeref(st.Description),
+			TargetURL:   safeDeref(st.TargetURL),
+		})
+	}
+
+	checks := make([]CheckRunInfo, 0)
+	checkOpts := &github.ListCheckRunsOptions{ListOptions: github.ListOptions{PerPage: maxPerPage}}
+	for {
+		var runs *github.ListCheckRunsResults
+		var resp *github.Response
+		err := withRetryBudget(ctx, func() (err error) {
+			runs, resp, err = githubClient.Checks.ListCheckRunsForRef(ctx, githubOwner, githubRepo, sha, checkOpts)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("listing check runs: %w", err)
+		}
+		for _, run := range runs.CheckRuns {
+			checks = append(checks, CheckRunInfo{
+				Name:       safeDeref(run.Name),
+				Status:     safeDeref(run.Status),
+				Conclusion: safeDeref(run.Conclusion),
+				HTMLURL:    safeDeref(run.HTMLURL),
+			})
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		checkOpts.Page = resp.NextPage
+	}
+
+	// Any failure wins; otherwise anything unfinished (or no signal at all) is pending.
+	verdict := "success"
+	if len(statuses) == 0 && len(checks) == 0 {
+		verdict = "pending"
+	}
+	for _, st := range statuses {
+		switch st.State {
+		case "failure", "error":
+			verdict = "failure"
+		case "pending":
+			if verdict != "failure" {
+				verdict = "pending"
+			}
+		}
+	}
+	for _, c := range checks {
+		if failedConclusions[c.Conclusion] {
+			verdict = "failure"
+		} else if c.Status != "completed" && verdict != "failure" {
+			verdict = "pending"
+		}
+	}
+
+	return &commitStatus{SHA: safeDeref(combined.SHA), Verdict: verdict, Statuses: statuses, CheckRuns: checks}, nil
+}
+
+// githubTeamsHandler lists the teams with access to the repository and their
+// permission level. Teams only exist for organization-owned repositories.
+func githubTeamsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if githubClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "GitHub client not initialized")
+		return
+	}
+
+	type TeamInfo struct {
+		Name       string `json:"name"`
+		Slug       string `json:"slug"`
+		Permission string `json:"permission"` // admin, maintain, push, triage or pull
+	}
+
+	teams := make([]TeamInfo, 0)
+	opts := &github.ListOptions{PerPage: maxPerPage}
+	for {
+		ctx, cancel := githubContext(r)
+		var page []*github.Team
+		var resp *github.Response
+		err := withRetryBudget(ctx, func() (err error) {
+			page, resp, err = githubClient.Repositories.ListTeams(ctx, githubOwner, githubRepo, opts)
+			return err
+		})
+		cancel()
+		if err != nil {
+			if isGitHubNotFound(err) {
+				// Also what GitHub returns for user-owned repos, which have no teams.
+				writeErrorJSON(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("No teams found: %s/%s must be an organization repository and the token needs read:org", githubOwner, githubRepo))
+				return
+			}
+			log.Printf("Error getting GitHub teams: %v", err)
+			writeGitHubError(w, "Error getting GitHub teams", err)
+			return
+		}
+		for _, team := range page {
+			teams = append(teams, TeamInfo{
+				Name:       safeDeref(team.Name),
+				Slug:       safeDeref(team.Slug),
+				Permission: teamPermission(team),
+			})
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	writeJSON(w, r, teams)
+}
+
+// teamPermission returns a team's highest permission on the repository. The
+// legacy Permission field only distinguishes pull/push/admin, so the granular
+// Permissions map is preferred when present.
+func teamPermission(team *github.Team) string {
+	for _, level := range []string{"admin", "maintain", "push", "triage", "pull"} {
+		if team.Permissions[level] {
+			return level
+		}
+	}
+	return safeDeref(team.Permission)
+}
+
+// trafficDay is one day of repository views or clones.
+type trafficDay struct {
+	Date    string `json:"date"` // YYYY-MM-DD (UTC)
+	Count   int    `json:"count"`
+	Uniques int    `json:"uniques"`
+}
+
+// trafficDays flattens GitHub's daily traffic data.
+func trafficDays(data []*github.TrafficData) []trafficDay {
+	days := make([]trafficDay, 0, len(data))
+	for _, d := range data {
+		day := trafficDay{Count: safeDerefInt(d.Count), Uniques: safeDerefInt(d.Uniques)}
+		if d.Timestamp != nil {
+			day.Date = d.Timestamp.UTC().Format("2006-01-02")
+		}
+		days = append(days, day)
+	}
+	return days
+}
+
+// githubTrafficHandler returns daily views and clones for the last 14 days.
+// GitHub only exposes traffic to tokens with push access to the repository.
+func githubTrafficHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if githubClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "GitHub client not initialized")
+		return
+	}
+
+	ctx, cancel := githubContext(r)
+	defer cancel()
+
+	daily := &github.TrafficBreakdownOptions{Per: "day"}
+	var views *github.TrafficViews
+	var clones *github.TrafficClones
+	err := withRetryBudget(ctx, func() (err error) {
+		views, _, err = githubClient.Repositories.ListTrafficViews(ctx, githubOwner, githubRepo, daily)
+		return err
+	})
+	if err == nil {
+		err = withRetryBudget(ctx, func() (err error) {
+			clones, _, err = githubClient.Repositories.ListTrafficClones(ctx, githubOwner, githubRepo, daily)
+			return err
+		})
+	}
+	if err != nil {
+		if status := githubErrorStatus(err); status == http.StatusForbidden || status == http.StatusNotFound {
+			writeErrorJSON(w, http.StatusForbidden, codeForbidden, "Repository traffic requires a GitHub token with push access to the repository")
+			return
+		}
+		log.Printf("Error getting GitHub traffic: %v", err)
+		writeGitHubError(w, "Error getting GitHub traffic", err)
+		return
+	}
+
+	writeJSON(w, r, map[string]interface{}{
+		"views": map[string]interface{}{
+			"count":   safeDerefInt(views.Count),
+			"uniques": safeDerefInt(views.Uniques),
+			"daily":   trafficDays(views.Views),
+		},
+		"clones": map[string]interface{}{
+			"count":   safeDerefInt(clones.Count),
+			"uniques": safeDerefInt(clones.Uniques),
+			"daily":   trafficDays(clones.Clones),
+		},
+	})
+}
+
+// githubMeHandler reports which GitHub identity the configured token acts as, and
+// its OAuth scopes. The token itself is never returned.
+func githubMeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if githubClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "GitHub client not initialized")
+		return
+	}
+
+	ctx, cancel := githubContext(r)
+	defer cancel()
+
+	var user *github.User
+	var resp *github.Response
+	err := withRetryBudget(ctx, func() (err error) {
+		user, resp, err = githubClient.Users.Get(ctx, "")
+		return err
+	})
+	if err != nil {
+		log.Printf("Error getting authenticated GitHub user: %v", err)
+		writeGitHubError(w, "Error getting authenticated GitHub user", err)
+		return
+	}
+
+	// Classic tokens list their scopes in X-OAuth-Scopes; fine-grained and app tokens send none.
+	scopes := make([]string, 0)
+	for _, scope := range strings.Split(resp.Header.Get("X-OAuth-Scopes"), ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	writeJSON(w, r, map[string]interface{}{
+		"login":  safeDeref(user.Login),
+		"type":   safeDeref(user.Type),
+		"scopes": scopes,
+	})
+}
+
+// webhookInfo is one repository webhook as reported by /api/github-webhooks.
+type webhookInfo struct {
+	ID                 int64    `json:"id"`
+	Name               string   `json:"name"`
+	Active             bool     `json:"active"`
+	Events             []string `json:"events"`
+	URL                string   `json:"url"`        // target URL, credentials and query values redacted
+	HasSecret          bool     `json:"has_secret"` // the secret itself is never returned
+	LastResponseCode   *int     `json:"last_response_code"`
+	LastResponseStatus string   `json:"last_response_status,omitempty"` // e.g. "active", "unused" or "failed"
+	LastResponseText   string   `json:"last_response_message,omitempty"`
+}
+
+// githubWebhooksHandler lists the repository's webhooks with their events and last
+// delivery result, to find dead or misconfigured hooks. Listing hooks needs admin
+// access to the repository.
+func githubWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if githubClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "GitHub client not initialized")
+		return
+	}
+
+	hooks := make([]webhookInfo, 0)
+	opts := &github.ListOptions{PerPage: maxPerPage}
+	for {
+		ctx, cancel := githubContext(r)
+		var page []*github.Hook
+		var resp *github.Response
+		err := withRetryBudget(ctx, func() (err error) {
+			page, resp, err = githubClient.Repositories.ListHooks(ctx, githubOwner, githubRepo, opts)
+			return err
+		})
+		cancel()
+		if err != nil {
+			// GitHub hides hooks behind a 404 as well as a 403 when the token isn't a repo admin.
+			if status := githubErrorStatus(err); status == http.StatusNotFound || status == http.StatusForbidden {
+				writeErrorJSON(w, status, codeForStatus(status), fmt.Sprintf("Webhooks for %s/%s are not visible to this token; it needs admin access to the repository (admin:repo_hook scope or Webhooks read permission)", githubOwner, githubRepo))
+				return
+			}
+			log.Printf("Error getting GitHub webhooks: %v", err)
+			writeGitHubError(w, "Error getting GitHub webhooks", err)
+			return
+		}
+		for _, hook := range page {
+			hooks = append(hooks, newWebhookInfo(hook))
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	writeJSON(w, r, hooks)
+}
+
+// newWebhookInfo flattens a hook. Config and LastResponse are untyped maps in the
+// API, so every field is type-checked rather than assumed.
+func newWebhookInfo(hook *github.Hook) webhookInfo {
+	info := webhookInfo{
+		Name:   safeDeref(hook.Name),
+		Active: hook.Active != nil && *hook.Active,
+		Events: hook.Events,
+	}
+	if hook.ID != nil {
+		info.ID = *hook.ID
+	}
+	if info.Events == nil {
+		info.Events = []string{}
+	}
+	if target, ok := hook.Config["url"].(string); ok {
+		info.URL = redactURL(target)
+	}
+	if secret, ok := hook.Config["secret"].(string); ok && secret != "" {
+		info.HasSecret = true
+	}
+	if code, ok := hook.LastResponse["code"].(float64); ok {
+		c := int(code)
+		info.LastResponseCode = &c
+	}
+	if status, ok := hook.LastResponse["status"].(string); ok {
+		info.LastResponseStatus = status
+	}
+	if message, ok := hook.LastResponse["message"].(string); ok {
+		info.LastResponseText = message
+	}
+	return info
+}
+
+// redactURL hides credentials that are sometimes embedded in webhook URLs: the
+// userinfo and every query value. Unparseable URLs are withheld entirely.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "[redacted]"
+	}
+	if u.User != nil {
+		u.User = url.User("redacted")
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for key := range query {
+			query.Set(key, "redacted")
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// githubMilestonesHandler lists the repository's milestones with their issue counts
+// and completion percentage. ?state= is open (default), closed or all.
+func githubMilestonesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if githubClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "GitHub client not initialized")
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	if state == "" {
+		state = "open"
+	}
+	if state != "open" && state != "closed" && state != "all" {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid 'state' parameter '%s', expected 'open', 'closed' or 'all'", state))
+		return
+	}
+	listOpts, err := parsePagination(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := githubContext(r)
+	defer cancel()
+
+	var milestones []*github.Milestone
+	var ghResp *github.Response
+	err = withRetryBudget(ctx, func() (err error) {
+		milestones, ghResp, err = githubClient.Issues.ListMilestones(ctx, githubOwner, githubRepo, &github.MilestoneListOptions{
+			State:       state,
+			Sort:        "due_on",
+			ListOptions: listOpts,
+		})
+		return err
+	})
+	if err != nil {
+		log.Printf("Error getting GitHub milestones: %v", err)
+		writeGitHubError(w, "Error getting GitHub milestones", err)
+		return
+	}
+
+	type MilestoneInfo struct {
+		Number       int     `json:"number"`
+		Title        string  `json:"title"`
+		State        string  `json:"state"`
+		DueOn        string  `json:"due_on,omitempty"`
+		OpenIssues   int     `json:"open_issues"`
+		ClosedIssues int     `json:"closed_issues"`
+		Percent      float64 `json:"percent_complete"` // closed / (open + closed); 0 when empty
+		HTMLURL      string  `json:"html_url"`
+	}
+	infos := make([]MilestoneInfo, 0, len(milestones))
+	for _, m := range milestones {
+		info := MilestoneInfo{
+			Number:       safeDerefInt(m.Number),
+			Title:        safeDeref(m.Title),
+			State:        safeDeref(m.State),
+			OpenIssues:   safeDerefInt(m.OpenIssues),
+			ClosedIssues: safeDerefInt(m.ClosedIssues),
+			HTMLURL:      safeDeref(m.HTMLURL),
+		}
+		if m.DueOn != nil {
+			info.DueOn = m.DueOn.Format(time.RFC3339)
+		}
+		if total := info.OpenIssues + info.ClosedIssues; total > 0 {
+			info.Percent = math.Round(float64(info.ClosedIssues)/float64(total)*1000) / 10
+		}
+		infos = append(infos, info)
+	}
+
+	setPaginationHeaders(w, listOpts, ghResp)
+	writeJSON(w, r, infos)
+}
+
+// githubSecurityHandler reports whether vulnerability alerts are enabled for the
+// repository and how many Dependabot alerts are open, by severity. Reading the
+// setting needs admin read access and the alerts need Dependabot alerts read
+// permission (security_events for classic tokens); either missing is a 403.
+func githubSecurityHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if githubClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "GitHub client not initialized")
+		return
+	}
+
+	ctx, cancel := githubContext(r)
+	defer cancel()
+
+	// go-github reports GitHub's 404 for "disabled" as false rather than an error.
+	var enabled bool
+	err := withRetryBudget(ctx, func() (err error) {
+		enabled, _, err = githubClient.Repositories.GetVulnerabilityAlerts(ctx, githubOwner, githubRepo)
+		return err
+	})
+	if err != nil {
+		if githubErrorStatus(err) == http.StatusForbidden {
+			writeErrorJSON(w, http.StatusForbidden, codeForbidden, "Checking vulnerability alerts requires a GitHub token with admin read access to the repository")
+			return
+		}
+		log.Printf("Error getting GitHub vulnerability alerts setting: %v", err)
+		writeGitHubError(w, "Error getting GitHub vulnerability alerts setting", err)
+		return
+	}
+	result := map[string]interface{}{
+		"vulnerability_alerts_enabled": enabled,
+		"open_alerts":                  nil,
+		"by_severity":                  map[string]int{},
+	}
+	if !enabled {
+		result["message"] = "Vulnerability alerts are disabled for this repository."
+		writeJSON(w, r, result)
+		return
+	}
+
+	bySeverity := make(map[string]int)
+	open := 0
+	opts := &github.ListAlertsOptions{State: github.String("open")}
+	opts.ListCursorOptions.PerPage = maxPerPage
+	for {
+		var alerts []*github.DependabotAlert
+		var resp *github.Response
+		err := withRetryBudget(ctx, func() (err error) {
+			alerts, resp, err = githubClient.Dependabot.ListRepoAlerts(ctx, githubOwner, githubRepo, opts)
+			return err
+		})
+		if err != nil {
+			switch githubErrorStatus(err) {
+			case http.StatusForbidden:
+				writeErrorJSON(w, http.StatusForbidden, codeForbidden, "Dependabot alerts require a GitHub token with Dependabot alerts read permission (security_events scope for classic tokens)")
+				return
+			case http.StatusNotFound:
+				result["message"] = "Dependabot alerts are not enabled for this repository."
+				writeJSON(w, r, result)
+				return
+			}
+			log.Printf("Error listing GitHub Dependabot alerts: %v", err)
+			writeGitHubError(w, "Error listing GitHub Dependabot alerts", err)
+			return
+		}
+		for _, alert := range alerts {
+			open++
+			if severity := alert.GetSecurityAdvisory().GetSeverity(); severity != "" {
+				bySeverity[severity]++
+			}
+		}
+		if resp.After == "" {
+			break
+		}
+		opts.ListCursorOptions.After = resp.After
+	}
+
+	result["open_alerts"] = open
+	result["by_severity"] = bySeverity
+	writeJSON(w, r, result)
+}
+
+// githubActionsUsageHandler reports the repository owner's GitHub Actions minutes
+// for the current billing cycle: included, used and paid minutes, with used minutes
+// broken down by runner OS. Billing is per account, so it uses the organization or
+// user billing API depending on what GITHUB_OWNER is. Both need an admin token;
+// without one GitHub answers 403 or 404, reported here as a 403.
+func githubActionsUsageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if githubClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "GitHub client not initialized")
+		return
+	}
+
+	ctx, cancel := githubContext(r)
+	defer cancel()
+
+	var owner *github.User
+	err := withRetryBudget(ctx, func() (err error) {
+		owner, _, err = githubClient.Users.Get(ctx, githubOwner)
+		return err
+	})
+	if err != nil {
+		log.Printf("Error getting GitHub account %s: %v", githubOwner, err)
+		writeGitHubError(w, "Error getting GitHub account", err)
+		return
+	}
+	isOrg := owner.GetType() == "Organization"
+
+	var billing *github.ActionBilling
+	err = withRetryBudget(ctx, func() (err error) {
+		if isOrg {
+			billing, _, err = githubClient.Billing.GetActionsBillingOrg(ctx, githubOwner)
+		} else {
+			billing, _, err = githubClient.Billing.GetActionsBillingUser(ctx, githubOwner)
+		}
+		return err
+	})
+	if err != nil {
+		if status := githubErrorStatus(err); status == http.StatusForbidden || status == http.StatusNotFound {
+			msg := "Actions billing for a user requires a GitHub token for that user with the user scope"
+			if isOrg {
+				msg = "Actions billing for an organization requires a GitHub token from an organization owner or billing manager (admin:org scope for classic tokens)"
+			}
+			writeErrorJSON(w, http.StatusForbidden, codeForbidden, msg)
+			return
+		}
+		log.Printf("Error getting GitHub Actions billing for %s: %v", githubOwner, err)
+		writeGitHubError(w, "Error getting GitHub Actions billing", err)
+		return
+	}
+
+	byOS := map[string]int{"linux": 0, "macos": 0, "windows": 0}
+	for machine, minutes := range billing.MinutesUsedBreakdown {
+		byOS[runnerOS(machine)] += minutes
+	}
+	ownerType := "user"
+	if isOrg {
+		ownerType = "organization"
+	}
+	writeJSON(w, r, map[string]interface{}{
+		"owner":                  githubOwner,
+		"owner_type":             ownerType,
+		"included_minutes":       billing.IncludedMinutes,
+		"total_minutes_used":     billing.TotalMinutesUsed,
+		"total_paid_minutes":     billing.TotalPaidMinutesUsed,
+		"minutes_used_by_os":     byOS,
+		"minutes_used_breakdown": billing.MinutesUsedBreakdown,
+	})
+}
+
+// runnerOS maps a billing machine type such as "UBUNTU", "MACOS_12_CORE" or
+// "WINDOWS_8_CORE" to linux, macos or windows; anything else is "other".
+func runnerOS(machine string) string {
+	switch machine = strings.ToUpper(machine); {
+	case strings.HasPrefix(machine, "UBUNTU"), strings.HasPrefix(machine, "LINUX"):
+		return "linux"
+	case strings.HasPrefix(machine, "MACOS"):
+		return "macos"
+	case strings.HasPrefix(machine, "WINDOWS"):
+		return "windows"
+	}
+	return "other"
+}
+
+// githubErrorStatus returns the HTTP status of a GitHub API error response, or 0
+// if err isn't one.
+func githubErrorStatus(err error) int {
+	var ghErr *github.ErrorResponse
+	if errors.As(err, &ghErr) && ghErr.Response != nil {
+		return ghErr.Response.StatusCode
+	}
+	return 0
+}
+
+// isGitHubNotFound reports whether err is a GitHub API 404 response.
+func isGitHubNotFound(err error) bool {
+	return githubErrorStatus(err) == http.StatusNotFound
+}
+
+// safeDerefInt safely dereferences an int pointer, returning 0 if nil.
+func safeDerefInt(i *int) int {
+	if i != nil {
+		return *i
+	}
+	return 0
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+	"golang.org/x/oauth2"
+)
+
+// --- GitHub App Authentication ---
+
+// githubAppJWTLifetime is how long each App JWT is valid; GitHub allows at most 10
+// minutes, and only needs it for the installation token request.
+const githubAppJWTLifetime = 9 * time.Minute
+
+// githubAppTokenRefresh is how long before expiry an installation token is replaced,
+// so a request never goes out with a token that expires in flight.
+const githubAppTokenRefresh = 5 * time.Minute
+
+// githubApp holds GitHub App credentials for minting installation tokens.
+type githubApp struct {
+	appID          int64
+	installationID int64
+	key            *rsa.PrivateKey
+}
+
+// githubAppFromVault reads the App credentials from Vault's kv/cloudpulse:
+// github_app_id, github_app_installation_id and github_app_private_key (the PEM key
+// itself). It returns nil without an error when github_app_id is not there.
+func githubAppFromVault() (*githubApp, error) {
+	if vaultClient == nil {
+		return nil, nil
+	}
+	appID, err := getSecret("kv/cloudpulse", "github_app_id")
+	if err != nil || appID == "" {
+		return nil, nil // no App configured in Vault
+	}
+	values, err := getSecrets("kv/cloudpulse", []string{"github_app_installation_id", "github_app_private_key"})
+	if err != nil {
+		return nil, err
+	}
+	return newGitHubApp(appID, values["github_app_installation_id"], []byte(values["github_app_private_key"]))
+}
+
+// githubAppFromEnv reads GITHUB_APP_ID, GITHUB_APP_INSTALLATION_ID and
+// GITHUB_APP_PRIVATE_KEY_FILE (the PEM key downloaded from the App's settings). It
+// returns nil without an error when GITHUB_APP_ID is unset.
+func githubAppFromEnv() (*githubApp, error) {
+	rawID := os.Getenv("GITHUB_APP_ID")
+	if rawID == "" {
+		return nil, nil
+	}
+	keyFile := os.Getenv("GITHUB_APP_PRIVATE_KEY_FILE")
+	if keyFile == "" {
+		return nil, fmt.Errorf("GITHUB_APP_PRIVATE_KEY_FILE must be set with GITHUB_APP_ID")
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading GitHub App private key: %w", err)
+	}
+	return newGitHubApp(rawID, os.Getenv("GITHUB_APP_INSTALLATION_ID"), keyPEM)
+}
+
+// newGitHubApp parses App credentials in the form both sources store them.
+func newGitHubApp(rawID, rawInstallation string, keyPEM []byte) (*githubApp, error) {
+	appID, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub App ID %q", rawID)
+	}
+	installationID, err := strconv.ParseInt(rawInstallation, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub App installation ID %q", rawInstallation)
+	}
+	key, err := parseGitHubAppKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &githubApp{appID: appID, installationID: installationID, key: key}, nil
+}
+
+// parseGitHubAppKey parses an RSA private key in PEM form, PKCS#1 as GitHub issues
+// it or PKCS#8 after conversion.
+func parseGitHubAppKey(keyPEM []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("GitHub App private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing GitHub App private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("GitHub App private key is not an RSA key")
+	}
+	return key, nil
+}
+
+// jwt returns a signed RS256 JWT identifying the App. iat is backdated a minute to
+// allow for clock drift, as GitHub recommends.
+func (a *githubApp) jwt(now time.Time) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(githubAppJWTLifetime).Unix(),
+		"iss": strconv.FormatInt(a.appID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+	signingInput := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Token mints a new installation token, making githubApp an oauth2.TokenSource.
+// Installation tokens last an hour; use tokenSource to reuse them until close to
+// expiry rather than minting one per request.
+func (a *githubApp) Token() (*oauth2.Token, error) {
+	jwt, err := a.jwt(time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("signing GitHub App JWT: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), githubTimeout)
+	defer cancel()
+
+	client := github.NewClient(newGitHubHTTPClient()).WithAuthToken(jwt)
+	installation, _, err := client.Apps.CreateInstallationToken(ctx, a.installationID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating GitHub App installation token: %w", err)
+	}
+	return &oauth2.Token{AccessToken: installation.GetToken(), Expiry: installation.GetExpiresAt().Time}, nil
+}
+
+// tokenSource returns a token source that mints the first installation token now,
+// so bad credentials fail at startup, and a new one githubAppTokenRefresh before
+// each expires.
+func (a *githubApp) tokenSource() (oauth2.TokenSource, error) {
+	first, err := a.Token()
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.ReuseTokenSourceWithExpiry(first, a, githubAppTokenRefresh), nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/smithy-go"
+)
+
+// --- Readiness ---
+
+// readiness records the outcome of named dependency checks for /readyz.
+// A check is healthy when its message is "ok".
+type readiness struct {
+	mu     sync.Mutex
+	checks map[string]string
+}
+
+// Set records the result of a check; a nil err means healthy.
+func (rd *readiness) Set(name string, err error) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+
+	if err != nil {
+		rd.checks[name] = err.Error()
+		return
+	}
+	rd.checks[name] = "ok"
+}
+
+// Snapshot returns a copy of the checks and whether all of them are healthy.
+func (rd *readiness) Snapshot() (map[string]string, bool) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+
+	ready := true
+	checks := make(map[string]string, len(rd.checks))
+	for name, msg := range rd.checks {
+		checks[name] = msg
+		if msg != "ok" {
+			ready = false
+		}
+	}
+	return checks, ready
+}
+
+// appReadiness is the readiness state reported by /readyz.
+var appReadiness = &readiness{checks: make(map[string]string)}
+
+// readyzHandler reports 200 when every dependency check passes and 503 otherwise,
+// with the individual check results in the body.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	checks, ready := appReadiness.Snapshot()
+	status := "ready"
+	if !ready {
+		status = "not ready"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeJSON(w, r, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+// checkCloudWatchAccess makes two cheap calls to confirm the IAM role can read
+// metrics: a filtered ListMetrics and a one-query GetMetricData over five minutes.
+// Permission errors are reported with the missing action so operators can fix the role.
+func checkCloudWatchAccess(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := cwClient.ListMetrics(ctx, &cloudwatch.ListMetricsInput{
+		Namespace:      aws.String("AWS/EC2"),
+		MetricName:     aws.String("CPUUtilization"),
+		RecentlyActive: types.RecentlyActivePt3h,
+	})
+	if err != nil {
+		return describeAccessError("cloudwatch:ListMetrics", err)
+	}
+
+	endTime := time.Now()
+	startTime := endTime.Add(-5 * time.Minute)
+	_, err = cwClient.GetMetricData(ctx, &cloudwatch.GetMetricDataInput{
+		StartTime: &startTime,
+		EndTime:   &endTime,
+		MetricDataQueries: []types.MetricDataQuery{{
+			Id: aws.String("selfcheck"),
+			MetricStat: &types.MetricStat{
+				Metric: &types.Metric{
+					Namespace:  aws.String("AWS/EC2"),
+					MetricName: aws.String("CPUUtilization"),
+				},
+				Period: aws.Int32(300),
+				Stat:   aws.String("Average"),
+			},
+		}},
+	})
+	if err != nil {
+		return describeAccessError("cloudwatch:GetMetricData", err)
+	}
+	return nil
+}
+
+// describeAccessError turns an AWS access-denied error into a message naming the missing action.
+func describeAccessError(action string, err error) error {
+	if isAccessDenied(err) {
+		return fmt.Errorf("IAM role is missing permission %s", action)
+	}
+	return fmt.Errorf("%s failed: %v", action, err)
+}
+
+// isAccessDenied reports whether err is an AWS authorization failure.
+func isAccessDenied(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "AccessDenied", "AccessDeniedException", "UnauthorizedOperation":
+			return true
+		}
+	}
+	return false
+}
+
+// permissionCheck is one IAM action CloudPulse relies on, with a minimal call that exercises it.
+type permissionCheck struct {
+	Service string
+	Action  string
+	Feature string // what stops working without it
+	call    func(ctx context.Context) error
+}
+
+// permissionChecks lists the IAM actions used by enabled features. Add an entry
+// here when a feature starts calling a new AWS API.
+func permissionChecks() []permissionCheck {
+	recent := func() (*time.Time, *time.Time) {
+		end := time.Now()
+		start := end.Add(-5 * time.Minute)
+		return &start, &end
+	}
+	return []permissionCheck{
+		{"cloudwatch", "cloudwatch:GetMetricData", "ec2-usage, nat-usage, ecs-usage, ec2-idle, custom-metric", func(ctx context.Context) error {
+			start, end := recent()
+			_, err := cwClient.GetMetricData(ctx, &cloudwatch.GetMetricDataInput{
+				StartTime: start,
+				EndTime:   end,
+				MetricDataQueries: []types.MetricDataQuery{{
+					Id: aws.String("permcheck"),
+					MetricStat: &types.MetricStat{
+						Metric: &types.Metric{Namespace: aws.String("AWS/EC2"), MetricName: aws.String("CPUUtilization")},
+						Period: aws.Int32(300),
+						Stat:   aws.String("Average"),
+					},
+				}},
+			})
+			return err
+		}},
+		{"cloudwatch", "cloudwatch:GetMetricStatistics", "free-tier-usage", func(ctx context.Context) error {
+			start, end := recent()
+			_, err := cwClient.GetMetricStatistics(ctx, &cloudwatch.GetMetricStatisticsInput{
+				Namespace:  aws.String("AWS/EC2"),
+				MetricName: aws.String("CPUUtilization"),
+				StartTime:  start,
+				EndTime:    end,
+				Period:     aws.Int32(300),
+				Statistics: []types.Statistic{types.StatisticAverage},
+			})
+			return err
+		}},
+		{"cloudwatch", "cloudwatch:ListMetrics", "startup self-check", func(ctx context.Context) error {
+			_, err := cwClient.ListMetrics(ctx, &cloudwatch.ListMetricsInput{
+				Namespace:      aws.String("AWS/EC2"),
+				MetricName:     aws.String("CPUUtilization"),
+				RecentlyActive: types.RecentlyActivePt3h,
+			})
+			return err
+		}},
+		{"cloudwatch", "cloudwatch:ListDashboards", "cw-dashboards", func(ctx context.Context) error {
+			_, err := cwClient.ListDashboards(ctx, &cloudwatch.ListDashboardsInput{DashboardNamePrefix: aws.String("cloudpulse-permission-check")})
+			return err
+		}},
+		{"cloudwatch", "cloudwatch:DescribeAlarms", "deploy-readiness", func(ctx context.Context) error {
+			_, err := cwClient.DescribeAlarms(ctx, &cloudwatch.DescribeAlarmsInput{MaxRecords: aws.Int32(1)})
+			return err
+		}},
+		{"ec2", "ec2:DescribeInstances", "ec2-usage ?diagnose=true", func(ctx context.Context) error {
+			_, err := ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{MaxResults: aws.Int32(5)})
+			return err
+		}},
+	}
+}
+
+// permissionsCheckHandler runs a minimal call for each IAM action CloudPulse uses
+// and reports ok / denied / error per action, naming the action to grant.
+func permissionsCheckHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if cwClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "AWS client not initialized")
+		return
+	}
+
+	type CheckResult struct {
+		Service string `json:"service"`
+		Action  string `json:"action"`
+		Feature string `json:"feature"`
+		Status  string `json:"status"` // ok, denied or error
+		Message string `json:"message,omitempty"`
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 20*time.Second)
+	defer cancel()
+
+	checks := permissionChecks()
+	results := make([]CheckResult, 0, len(checks))
+	allOK := true
+	for _, check := range checks {
+		res := CheckResult{Service: check.Service, Action: check.Action, Feature: check.Feature, Status: "ok"}
+		if err := check.call(ctx); err != nil {
+			allOK = false
+			res.Status = "error"
+			res.Message = err.Error()
+			if isAccessDenied(err) {
+				res.Status = "denied"
+				res.Message = fmt.Sprintf("Grant %s to the IAM role", check.Action)
+			}
+		}
+		results = append(results, res)
+	}
+
+	writeJSON(w, r, map[string]interface{}{
+		"allOk":  allOK,
+		"checks": results,
+	})
+}
+
+// runCloudWatchSelfCheck runs checkCloudWatchAccess, logs the outcome and records it for /readyz.
+func runCloudWatchSelfCheck() {
+	err := checkCloudWatchAccess(context.Background())
+	if err != nil {
+		log.Printf("WARNING: CloudWatch self-check failed: %v", err)
+	} else {
+		log.Println("CloudWatch self-check passed: metrics are readable.")
+	}
+	appReadiness.Set("cloudwatch", err)
+}
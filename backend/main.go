@@ -1,32 +1,81 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 	"github.com/aws/aws-sdk-go-v2/aws" // <-- ADDED for SDK helpers (aws.String, aws.Int32)
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/budgets"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/google/go-github/v58/github" // Ensure this matches your go.mod
 	vault "github.com/hashicorp/vault/api"
 	"golang.org/x/oauth2"
 )
 
+// CloudWatchAPI is the subset of the CloudWatch client the handlers depend on.
+// *cloudwatch.Client satisfies it; tests and local tooling can substitute a fake.
+type CloudWatchAPI interface {
+	GetMetricData(ctx context.Context, params *cloudwatch.GetMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricDataOutput, error)
+	GetMetricStatistics(ctx context.Context, params *cloudwatch.GetMetricStatisticsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricStatisticsOutput, error)
+	ListDashboards(ctx context.Context, params *cloudwatch.ListDashboardsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.ListDashboardsOutput, error)
+	ListMetrics(ctx context.Context, params *cloudwatch.ListMetricsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.ListMetricsOutput, error)
+	GetDashboard(ctx context.Context, params *cloudwatch.GetDashboardInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetDashboardOutput, error)
+	DescribeAlarms(ctx context.Context, params *cloudwatch.DescribeAlarmsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.DescribeAlarmsOutput, error)
+}
+
 // Global variables for clients - initialize once
 var (
-	cwClient     *cloudwatch.Client
-	githubClient *github.Client
-	vaultClient  *vault.Client
-	instanceID   string // Store EC2 Instance ID
-	githubOwner  string // GitHub Repo Owner
-	githubRepo   string // GitHub Repo Name
+	cwClient      CloudWatchAPI
+	awsConfig     aws.Config // loaded by initAWS; the base for per-region clients
+	stsClient     *sts.Client
+	ec2Client     *ec2.Client
+	budgetsClient *budgets.Client
+	githubClient  *github.Client
+	vaultClient   *vault.Client
+	instanceID    string        // Store EC2 Instance ID
+	githubOwner   string        // GitHub Repo Owner
+	githubRepo    string        // GitHub Repo Name
+	basePath      string        // URL prefix when served behind a reverse proxy, e.g. "/cloudpulse" ("" for root)
+	pollInterval  time.Duration // Suggested frontend refresh interval, from POLL_INTERVAL
+
+	metricCacheTTL = 60 * time.Second // How long EC2 metric responses are cached, from METRIC_CACHE_TTL
+	debugLogging   bool               // Verbose logging, enabled with LOG_LEVEL=debug
+	cacheSWR       bool               // Serve expired metric results while refreshing, from CACHE_MODE=swr
+
+	maxResponsePoints = defaultMaxResponsePoints // Datapoint budget per metrics request, from MAX_RESPONSE_POINTS
+	envelopeDefault   bool                       // Wrap JSON responses in {data, meta, errors}, from ENVELOPE=true
+	pollJitter        time.Duration              // Random spread added to the Retry-After poll hint, from POLL_JITTER
+
+	githubTimeout = 10 * time.Second // Per-call GitHub API timeout, from GITHUB_TIMEOUT
+	githubPerPage = maxPerPage       // Default page size for GitHub list calls, from GITHUB_PER_PAGE
+)
+
+// Default CloudWatch query window and period (seconds) for the EC2 metrics view.
+const (
+	defaultMetricWindow       = 10 * time.Minute
+	defaultMetricPeriod int32 = 300
 )
 
 // --- Vault Functions ---
@@ -35,8 +84,7 @@ var (
 func initVault() error {
 	conf := vault.DefaultConfig() // Reads VAULT_ADDR from env (e.g., http://127.0.0.1:8201)
 
-	var err error
-	vaultClient, err = vault.NewClient(conf)
+	client, err := vault.NewClient(conf)
 	if err != nil {
 		return fmt.Errorf("failed to create vault client: %w", err)
 	}
@@ -45,139 +93,608 @@ func initVault() error {
 	if token == "" {
 		return fmt.Errorf("VAULT_TOKEN environment variable not set")
 	}
-	vaultClient.SetToken(token)
+	client.SetToken(token)
+	vaultClient = client
 
 	log.Println("Vault client initialized successfully.")
 	return nil
 }
 
-// getSecret fetches a secret from Vault's KVv2 store.
+// getSecret fetches a single key from Vault's KVv2 store.
 func getSecret(secretPath, key string) (string, error) {
-	if vaultClient == nil {
-		return "", fmt.Errorf("vault client not initialized")
+	values, err := getSecrets(secretPath, []string{key})
+	if err != nil {
+		return "", err
+	}
+	return values[key], nil
+}
+
+// splitSecretPath splits a KVv2 secret path like "kv/cloudpulse" into its mount and
+// the path within the mount, rejecting malformed paths before they reach Vault.
+func splitSecretPath(secretPath string) (string, string, error) {
+	switch {
+	case secretPath == "":
+		return "", "", fmt.Errorf("secret path is empty, expected 'mount/path'")
+	case strings.HasPrefix(secretPath, "/"):
+		return "", "", fmt.Errorf("invalid secret path '%s': must not start with '/', expected 'mount/path'", secretPath)
+	case strings.HasSuffix(secretPath, "/"):
+		return "", "", fmt.Errorf("invalid secret path '%s': must not end with '/', expected 'mount/path'", secretPath)
+	}
+	mountPath, pathWithinMount, found := strings.Cut(secretPath, "/")
+	if !found {
+		return "", "", fmt.Errorf("invalid secret path '%s': missing mount, expected 'mount/path'", secretPath)
 	}
+	if pathWithinMount == "" || strings.Contains(secretPath, "//") {
+		return "", "", fmt.Errorf("invalid secret path '%s': empty path segment, expected 'mount/path'", secretPath)
+	}
+	return mountPath, pathWithinMount, nil
+}
 
+// getSecrets reads a KVv2 secret once and extracts several keys from it,
+// saving a Vault round trip per key when init code needs multiple values.
+func getSecrets(secretPath string, keys []string) (map[string]string, error) {
 	// For KVv2, the API path is 'mount/data/path'. We need to extract mount and path.
-	parts := strings.SplitN(secretPath, "/", 2)
-	if len(parts) != 2 {
-		return "", fmt.Errorf("invalid secret path format '%s', expected 'mount/path'", secretPath)
+	mountPath, pathWithinMount, err := splitSecretPath(secretPath)
+	if err != nil {
+		return nil, err
 	}
-	mountPath := parts[0]
-	pathWithinMount := parts[1]
 
-	log.Printf("Fetching secret '%s' from Vault mount '%s' path '%s'\n", key, mountPath, pathWithinMount)
+	if vaultClient == nil {
+		return nil, fmt.Errorf("vault client not initialized")
+	}
+
+	log.Printf("Fetching secrets %v from Vault mount '%s' path '%s'\n", keys, mountPath, pathWithinMount)
 	secret, err := vaultClient.KVv2(mountPath).Get(context.Background(), pathWithinMount)
 	if err != nil {
-		return "", fmt.Errorf("failed to get secret from Vault (path: %s): %w", secretPath, err)
+		return nil, fmt.Errorf("failed to get secret from Vault (path: %s): %w", secretPath, err)
 	}
 
 	if secret == nil || secret.Data == nil {
-		return "", fmt.Errorf("no data found at secret path '%s'", secretPath)
+		return nil, fmt.Errorf("no data found at secret path '%s'", secretPath)
 	}
 
-	value, ok := secret.Data[key].(string)
-	if !ok {
-		return "", fmt.Errorf("secret key '%s' not found or not a string in path '%s'", key, secretPath)
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		value, ok := secret.Data[key].(string)
+		if !ok {
+			return nil, fmt.Errorf("secret key '%s' not found or not a string in path '%s'", key, secretPath)
+		}
+		values[key] = value
 	}
 
-	log.Printf("Successfully fetched secret '%s' from Vault.", key)
-	return value, nil
+	log.Printf("Successfully fetched secrets %v from Vault.", keys)
+	return values, nil
 }
 
 // --- AWS Functions ---
 
-// initAWS initializes the AWS CloudWatch client and fetches the instance ID.
+// initAWS initializes the AWS CloudWatch, STS, EC2 and Budgets clients and fetches the instance ID.
 func initAWS() error {
 	cfg, err := config.LoadDefaultConfig(context.TODO())
 	if err != nil {
 		return fmt.Errorf("failed to load AWS config: %w", err)
 	}
-	cwClient = cloudwatch.NewFromConfig(cfg)
+	// AWS_ENDPOINT_URL points CloudWatch at a custom endpoint (e.g. LocalStack at
+	// http://localhost:4566) for integration testing. Unset means real AWS.
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		log.Printf("Using custom CloudWatch endpoint: %s", endpoint)
+		cwClient = cloudwatch.NewFromConfig(cfg, withoutSDKRetries, func(o *cloudwatch.Options) {
+			o.BaseEndpoint = aws.String(endpoint)
+		})
+	} else {
+		cwClient = cloudwatch.NewFromConfig(cfg, withoutSDKRetries)
+	}
+	awsConfig = cfg
+	stsClient = sts.NewFromConfig(cfg)
+	ec2Client = ec2.NewFromConfig(cfg)
+	budgetsClient = budgets.NewFromConfig(cfg)
 
-	// Fetch instance ID using HTTP GET from metadata service (simpler & reliable)
-	metadataURL := "http://169.254.169.254/latest/meta-data/instance-id"
-	// Set a timeout for the HTTP request to avoid hangs if metadata service is not available
-	client := http.Client{Timeout: 2 * time.Second}
-	resp, err := client.Get(metadataURL)
+	// Stop calling CloudWatch for a while when it is failing, rather than piling on.
+	// The breaker also retries transient failures within the request's retry budget.
+	cwClient = withCircuitBreaker(cwClient)
 
-	if err != nil {
-		log.Printf("Could not fetch EC2 instance ID via HTTP: %v. Using override or placeholder.", err)
-		instanceID = os.Getenv("EC2_INSTANCE_ID_OVERRIDE")
+	// Fail readiness early, with a clear message, if the role can't read metrics.
+	runCloudWatchSelfCheck()
+
+	refreshInstanceID()
+	log.Println("AWS CloudWatch client initialized. Instance ID:", currentInstanceID())
+	return nil
+}
+
+// Instance ID resolution, highest precedence first:
+//  1. ?instance= on the request (per request, see requestInstanceID)
+//  2. EC2_INSTANCE_ID_OVERRIDE
+//  3. the EC2 instance metadata service
+//
+// The resolved ID is cached in instanceID and can be re-resolved with refreshInstanceID,
+// e.g. after CloudPulse moves to another host.
+var instanceMu sync.RWMutex
+
+// currentInstanceID returns the cached monitored instance ID ("" if unresolved).
+func currentInstanceID() string {
+	instanceMu.RLock()
+	defer instanceMu.RUnlock()
+	return instanceID
+}
+
+// refreshInstanceID re-resolves the monitored instance from the environment or the
+// metadata service. It returns the previous and current IDs and where the current
+// one came from; a failed lookup keeps the previous value and returns source "".
+func refreshInstanceID() (previous, current, source string) {
+	id, source := resolveInstanceID()
+
+	instanceMu.Lock()
+	defer instanceMu.Unlock()
+	previous = instanceID
+	if id == "" {
 		if instanceID == "" {
-			log.Println("EC2_INSTANCE_ID_OVERRIDE not set. EC2 metrics will likely fail unless on EC2.")
-		} else {
-			log.Printf("Using EC2_INSTANCE_ID_OVERRIDE: %s", instanceID)
+			log.Println("EC2 instance ID could not be determined and EC2_INSTANCE_ID_OVERRIDE is not set. EC2 metrics will fail unless ?instance= is given.")
 		}
-		// We return nil here, allowing the app to start even if metadata isn't found
-		// (useful for local testing, but the /api/ec2-usage will fail).
-		return nil
+		return previous, instanceID, ""
 	}
-	defer resp.Body.Close()
+	switch {
+	case instanceID != "" && id != instanceID:
+		log.Printf("WARNING: EC2 instance ID changed from %s to %s (from %s); monitoring the new instance.", instanceID, id, source)
+	case id != instanceID:
+		log.Printf("Using EC2 instance ID %s (from %s)", id, source)
+	}
+	instanceID = id
+	return previous, instanceID, source
+}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Metadata service returned non-200 status: %d. Using override or placeholder.", resp.StatusCode)
-		instanceID = os.Getenv("EC2_INSTANCE_ID_OVERRIDE")
-		if instanceID == "" {
-			log.Println("EC2_INSTANCE_ID_OVERRIDE not set. EC2 metrics will likely fail.")
-		} else {
-			log.Printf("Using EC2_INSTANCE_ID_OVERRIDE: %s", instanceID)
-		}
-		return nil
+// instanceIDRefreshHandler re-resolves the monitored instance on demand, e.g. while
+// debugging the metadata service or after EC2_INSTANCE_ID_OVERRIDE changed, and
+// reports the old and new IDs. It is protected by requireAPIKey.
+func instanceIDRefreshHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeErrorJSON(w, http.StatusMethodNotAllowed, codeInvalidRequest, "Use POST to refresh the instance ID")
+		return
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	previous, current, source := refreshInstanceID()
+	if source == "" {
+		writeErrorJSON(w, http.StatusServiceUnavailable, codeUnavailable, fmt.Sprintf("Could not resolve the instance ID from EC2_INSTANCE_ID_OVERRIDE or the metadata service; still using %q", current))
+		return
+	}
+	writeJSON(w, r, map[string]interface{}{
+		"old":     previous,
+		"new":     current,
+		"changed": previous != current,
+		"source":  source,
+	})
+}
+
+// startInstanceReconciler re-resolves the instance ID every interval until ctx is
+// cancelled, so a container that moved hosts stops reporting on the old instance.
+func startInstanceReconciler(ctx context.Context, interval time.Duration) {
+	log.Printf("Re-checking the EC2 instance ID every %s.", interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				refreshInstanceID()
+			}
+		}
+	}()
+}
+
+// resolveInstanceID returns the instance ID and where it came from, or "" if no source had one.
+func resolveInstanceID() (string, string) {
+	if id := os.Getenv("EC2_INSTANCE_ID_OVERRIDE"); id != "" {
+		return id, "EC2_INSTANCE_ID_OVERRIDE"
+	}
+	id, err := fetchMetadata("instance-id")
 	if err != nil {
-		return fmt.Errorf("failed to read EC2 instance ID response: %w", err)
+		log.Printf("Could not fetch EC2 instance ID from metadata service: %v", err)
+		return "", ""
 	}
-	instanceID = string(body)
+	return id, "metadata service"
+}
 
-	log.Println("AWS CloudWatch client initialized. Instance ID:", instanceID)
-	return nil
+// requestInstanceID returns the instance a request is about: ?instance= (an ID or
+// alias) if given, otherwise the monitored instance ("" if unresolved).
+func requestInstanceID(r *http.Request) (string, error) {
+	if raw := r.URL.Query().Get("instance"); raw != "" {
+		id := resolveInstance(raw)
+		if !strings.HasPrefix(id, "i-") {
+			return "", fmt.Errorf("invalid 'instance' parameter '%s', expected an instance ID like i-0abc123 or an INSTANCE_ALIASES name", raw)
+		}
+		return id, nil
+	}
+	return currentInstanceID(), nil
 }
 
-// --- GitHub Functions ---
+// metadataBaseURL is the EC2 instance metadata service root used by initAWS and instanceInfoHandler.
+const metadataBaseURL = "http://169.254.169.254/latest/meta-data/"
 
-// initGitHub initializes the GitHub client using a token from Vault.
-func initGitHub() error {
-	// We expect the path to be like 'kv/cloudpulse'
-	githubToken, err := getSecret("kv/cloudpulse", "github_token")
+// fetchMetadata reads a single value (e.g. "instance-type") from the EC2 metadata service.
+func fetchMetadata(path string) (string, error) {
+	client := http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(metadataBaseURL + path)
+	if err != nil {
+		return "", fmt.Errorf("metadata request for '%s' failed: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata service returned status %d for '%s'", resp.StatusCode, path)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", fmt.Errorf("failed to read metadata '%s': %w", path, err)
+	}
+	return string(body), nil
+}
+
+// detailedMonitoringEnabled reports whether EC2 detailed (one-minute) monitoring is
+// enabled for the instance. With basic monitoring, EC2 metrics only exist every five
+// minutes, so periods under 300 seconds come back empty.
+func detailedMonitoringEnabled(ctx context.Context, instance string) (bool, error) {
+	if ec2Client == nil {
+		return false, errors.New("EC2 client not initialized")
+	}
+	out, err := ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{instance}})
 	if err != nil {
-		return fmt.Errorf("failed to get GitHub token from Vault: %w", err)
+		return false, err
 	}
+	for _, reservation := range out.Reservations {
+		for _, inst := range reservation.Instances {
+			if inst.Monitoring != nil {
+				state := inst.Monitoring.State
+				return state == ec2types.MonitoringStateEnabled || state == ec2types.MonitoringStatePending, nil
+			}
+		}
+	}
+	return false, fmt.Errorf("instance %s not found", instance)
+}
+
+// --- GitHub Functions ---
 
+// initGitHub initializes the GitHub client using a token from resolveGitHubToken.
+func initGitHub() error {
 	githubOwner = os.Getenv("GITHUB_OWNER")
 	githubRepo = os.Getenv("GITHUB_REPO")
 	if githubOwner == "" || githubRepo == "" {
 		return fmt.Errorf("GITHUB_OWNER and GITHUB_REPO environment variables must be set")
 	}
 
-	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: githubToken})
-	tc := oauth2.NewClient(context.Background(), ts)
+	githubTimeout = durationFromEnv("GITHUB_TIMEOUT", 10*time.Second)
+	githubPerPage = intFromEnv("GITHUB_PER_PAGE", maxPerPage)
+	if githubPerPage > maxPerPage {
+		githubPerPage = maxPerPage
+	}
+
+	// oauth2 wraps the pooled transport from the context client; its Timeout isn't
+	// carried over, so it is set again on the returned client.
+	baseClient := newGitHubHTTPClient()
+	// Repeat GETs become conditional requests, so unchanged lists don't use up the rate limit.
+	githubETags = newETagTransport(baseClient.Transport)
+	baseClient.Transport = githubETags
+	ts, source, err := resolveGitHubToken()
+	if err != nil {
+		return err
+	}
+	tc := oauth2.NewClient(context.WithValue(context.Background(), oauth2.HTTPClient, baseClient), ts)
+	tc.Timeout = baseClient.Timeout
 	githubClient = github.NewClient(tc)
 
-	log.Println("GitHub client initialized for repo:", githubOwner+"/"+githubRepo)
+	log.Printf("GitHub client initialized for repo: %s/%s (token from %s)", githubOwner, githubRepo, source)
 	return nil
 }
 
+// resolveGitHubToken returns the GitHub token source to use and where it came from.
+// A GitHub App is preferred, for its higher rate limit and because it isn't tied to
+// a person: its credentials are read from Vault, then from GITHUB_APP_ID and
+// friends. Without an App, a personal access token is taken from github_token in
+// Vault (kv/cloudpulse), then from the GITHUB_TOKEN environment variable.
+func resolveGitHubToken() (oauth2.TokenSource, string, error) {
+	for _, source := range []struct {
+		name string
+		load func() (*githubApp, error)
+	}{{"Vault", githubAppFromVault}, {"GITHUB_APP_ID", githubAppFromEnv}} {
+		app, err := source.load()
+		if err != nil {
+			return nil, "", fmt.Errorf("GitHub App credentials from %s: %w", source.name, err)
+		}
+		if app == nil {
+			continue
+		}
+		ts, err := app.tokenSource()
+		if err != nil {
+			return nil, "", err
+		}
+		return ts, fmt.Sprintf("GitHub App %d installation %d, credentials from %s", app.appID, app.installationID, source.name), nil
+	}
+
+	if vaultClient != nil {
+		// We expect the path to be like 'kv/cloudpulse'
+		token, err := getSecret("kv/cloudpulse", "github_token")
+		if err == nil && token != "" {
+			return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}), "Vault", nil
+		}
+		log.Printf("No GitHub token from Vault, trying GITHUB_TOKEN next: %v", err)
+	}
+
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}), "GITHUB_TOKEN", nil
+	}
+	return nil, "", fmt.Errorf("no GitHub credentials found: store a GitHub App (github_app_id, github_app_installation_id, github_app_private_key) or github_token in Vault, or set GITHUB_APP_ID or GITHUB_TOKEN")
+}
+
+// newGitHubHTTPClient builds the HTTP client used for GitHub calls. All requests go to
+// api.github.com, so keep more idle connections per host than the default of 2.
+// The client Timeout is a backstop; per-request deadlines come from githubContext.
+func newGitHubHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = 20
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	transport.ResponseHeaderTimeout = githubTimeout
+	return &http.Client{
+		Transport: transport,
+		Timeout:   2 * githubTimeout,
+	}
+}
+
 // --- API Handlers ---
 
-// ec2UsageHandler fetches basic CloudWatch metrics.
+// ec2UsageHandler fetches basic CloudWatch metrics, serving repeat queries from the metric cache.
 func ec2UsageHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	if cwClient == nil {
-		http.Error(w, `{"error": "AWS client not initialized"}`, http.StatusInternalServerError)
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "AWS client not initialized")
 		return
 	}
-	if instanceID == "" {
-		http.Error(w, `{"error": "EC2 Instance ID not determined. Metrics unavailable."}`, http.StatusServiceUnavailable)
-		log.Println("EC2 Instance ID is empty, cannot fetch metrics.")
+
+	// ?format=csv exports series mode as a spreadsheet-friendly table.
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid 'format' parameter '%s', expected 'json' or 'csv'", format))
+		return
+	}
+	if format == "csv" && r.URL.Query().Get("mode") != "series" {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, "'format=csv' requires 'mode=series'")
+		return
+	}
+	// ?shape=columns returns series as parallel arrays instead of {timestamp, value} objects.
+	shape := r.URL.Query().Get("shape")
+	if shape != "" && shape != "objects" && shape != "columns" {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid 'shape' parameter '%s', expected 'objects' or 'columns'", shape))
+		return
+	}
+	if shape == "columns" && r.URL.Query().Get("mode") != "series" {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, "'shape=columns' requires 'mode=series'")
+		return
+	}
+	// ?group=bymetric nests each metric's value, unit, timestamp and status in one object.
+	group := r.URL.Query().Get("group")
+	if group != "" && group != "flat" && group != "bymetric" {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid 'group' parameter '%s', expected 'flat' or 'bymetric'", group))
+		return
+	}
+	if group == "bymetric" && (format == "csv" || shape == "columns") {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, "'group=bymetric' cannot be combined with 'format=csv' or 'shape=columns'")
 		return
 	}
 
-	endTime := time.Now()
-	startTime := endTime.Add(-10 * time.Minute)
+	// ?debug=raw (API key required, see requireAPIKey) bypasses the cache and the
+	// normal response shape to show exactly what CloudWatch returned. It is off unless
+	// ENABLED_ENDPOINTS lists "debug-raw".
+	if debug := r.URL.Query().Get("debug"); debug != "" {
+		if !endpointEnabled("debug-raw") {
+			writeErrorJSON(w, http.StatusForbidden, codeForbidden, "debug=raw is disabled; add debug-raw to ENABLED_ENDPOINTS to enable it")
+			return
+		}
+		if debug != "raw" {
+			writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid 'debug' parameter '%s', expected 'raw'", debug))
+			return
+		}
+		if format == "csv" || shape == "columns" || group == "bymetric" {
+			writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, "'debug=raw' cannot be combined with 'format=csv', 'shape=columns' or 'group=bymetric'")
+			return
+		}
+		raw, err := fetchEC2Usage(r.Context(), r)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		writeJSON(w, r, raw)
+		return
+	}
+
+	// X-Cache (HIT, STALE or MISS) also feeds meta.cached in the response envelope.
+	cacheKey := ec2CacheKey(r)
+	if cached, ok := responseCache.Get(cacheKey); ok {
+		w.Header().Set("X-Cache", "HIT")
+		setPollHint(w, responseCache.Remaining(cacheKey))
+		writeEC2Usage(w, r, cached.(map[string]interface{}))
+		return
+	}
+	if cacheSWR {
+		if stale, ok := responseCache.GetStale(cacheKey); ok {
+			w.Header().Set("X-Cache", "STALE")
+			setPollHint(w, 0) // the refresh started below should be done by then
+			go refreshEC2Cache(r.Clone(context.Background()), cacheKey)
+			// Copy before flagging so the cached map itself is never marked stale.
+			result := make(map[string]interface{}, len(stale.(map[string]interface{}))+1)
+			for k, v := range stale.(map[string]interface{}) {
+				result[k] = v
+			}
+			result["stale"] = true
+			writeEC2Usage(w, r, result)
+			return
+		}
+	}
+
+	result, err := fetchEC2Usage(r.Context(), r)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	responseCache.Set(cacheKey, result, ec2CacheTTL())
+
+	w.Header().Set("X-Cache", "MISS")
+	setPollHint(w, ec2CacheTTL())
+	writeEC2Usage(w, r, result)
+}
+
+// setPollHint tells clients when to poll again via Retry-After: once the cache entry
+// has expired (fresh for another `remaining`), plus a random delay of up to
+// POLL_JITTER so clients that honour it don't all refresh the moment it expires.
+// It is off unless POLL_JITTER is set.
+func setPollHint(w http.ResponseWriter, remaining time.Duration) {
+	if pollJitter <= 0 {
+		return
+	}
+	wait := remaining + time.Duration(rand.Int63n(int64(pollJitter)))
+	seconds := int(wait.Round(time.Second).Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// writeEC2Usage writes an ec2-usage result as JSON, as CSV for ?format=csv, with
+// parallel arrays for ?shape=columns, or with one object per metric for
+// ?group=bymetric.
+func writeEC2Usage(w http.ResponseWriter, r *http.Request, result map[string]interface{}) {
+	if r.URL.Query().Get("format") == "csv" {
+		writeSeriesCSV(w, r, result)
+		return
+	}
+	if r.URL.Query().Get("shape") == "columns" {
+		writeJSON(w, r, columnsFromSeries(result))
+		return
+	}
+	if r.URL.Query().Get("group") == "bymetric" {
+		writeJSON(w, r, groupByMetric(result))
+		return
+	}
+	writeJSON(w, r, result)
+}
+
+// refreshEC2Cache re-runs the query for a stale cache entry in the background.
+// Only one refresh per key runs at a time.
+func refreshEC2Cache(r *http.Request, cacheKey string) {
+	if !responseCache.StartRefresh(cacheKey) {
+		return
+	}
+	defer responseCache.FinishRefresh(cacheKey)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	result, err := fetchEC2Usage(ctx, r)
+	if err != nil {
+		log.Printf("Background refresh of %s failed: %v", cacheKey, err)
+		return
+	}
+	responseCache.Set(cacheKey, result, ec2CacheTTL())
+	debugf("Background refresh of %s complete", cacheKey)
+}
+
+// ec2CacheKey identifies an EC2 metrics query by its (sorted) query parameters.
+// Without ?instance= or ?asg= the query is about the monitored instance, so its
+// current ID is part of the key: after refreshInstanceID picks up a replacement,
+// the old instance's cached metrics are no longer served.
+func ec2CacheKey(r *http.Request) string {
+	query := r.URL.Query()
+	if query.Get("instance") == "" && query.Get("asg") == "" {
+		keyReq := r.Clone(r.Context())
+		query.Set("instance", currentInstanceID())
+		keyReq.URL.RawQuery = query.Encode()
+		return cacheKeyFor("ec2-usage", keyReq)
+	}
+	return cacheKeyFor("ec2-usage", r)
+}
+
+// cacheKeyFor identifies a query to endpoint by its (sorted) query parameters, so
+// entries for different endpoints never collide. Output-only parameters such as
+// ?pretty= and ?group= don't change the data and are left out.
+func cacheKeyFor(endpoint string, r *http.Request) string {
+	query := r.URL.Query()
+	query.Del("pretty")
+	query.Del("envelope")
+	query.Del("fields")
+	query.Del("group")
+	return endpoint + "?" + query.Encode()
+}
+
+// fetchEC2Usage runs the CloudWatch query described by r's parameters and builds
+// the response map. It is shared by ec2UsageHandler and the cache pre-warmer.
+func fetchEC2Usage(ctx context.Context, r *http.Request) (map[string]interface{}, error) {
+	// ?asg=my-group switches to Auto Scaling group-level metrics. CloudWatch aggregates
+	// AWS/EC2 metrics across the group under the AutoScalingGroupName dimension, which
+	// gives a stable view that survives instance churn.
+	asgName := r.URL.Query().Get("asg")
+	instance, err := requestInstanceID(r)
+	if err != nil {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	if asgName == "" && instance == "" {
+		log.Println("EC2 Instance ID is empty, cannot fetch metrics.")
+		return nil, &apiError{Status: http.StatusServiceUnavailable, Message: "EC2 Instance ID not determined. Metrics unavailable."}
+	}
+
+	dimensions := []types.Dimension{{Name: aws.String("InstanceId"), Value: aws.String(instance)}}
+	if asgName != "" {
+		dimensions = []types.Dimension{{Name: aws.String("AutoScalingGroupName"), Value: aws.String(asgName)}}
+	}
+
+	// ?source=cwagent reads memory/disk from the CloudWatch agent, which publishes
+	// under InstanceId plus ImageId/InstanceType (append_dimensions).
+	source := r.URL.Query().Get("source")
+	if source != "" && source != "ec2" && source != "cwagent" {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid 'source' parameter '%s', expected 'ec2' or 'cwagent'", source)}
+	}
+	if source == "cwagent" && asgName != "" {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: "'source=cwagent' is only supported for a single instance, not with 'asg'"}
+	}
+	if source == "cwagent" && instance != currentInstanceID() {
+		// The agent's ImageId/InstanceType dimensions are read from this host's metadata.
+		return nil, &apiError{Status: http.StatusBadRequest, Message: "'source=cwagent' is only supported for the monitored instance, not with 'instance'"}
+	}
+	memDimensions := dimensions
+	var agentDimensions []types.Dimension
+	if source == "cwagent" {
+		agentDimensions, err = cwAgentDimensions(instance)
+		if err != nil {
+			log.Printf("Could not build CWAgent dimensions: %v", err)
+			return nil, &apiError{Status: http.StatusServiceUnavailable, Message: fmt.Sprintf("Could not determine CloudWatch agent dimensions: %v", err), Err: err}
+		}
+		memDimensions = agentDimensions
+	}
+
+	startTime, endTime, period, err := parseWindowAndPeriod(r)
+	if err != nil {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	// ?mode=series returns every datapoint in the window instead of just the latest
+	// value; ?smooth= adds a server-side smoothed copy of each series.
+	mode := r.URL.Query().Get("mode")
+	if mode != "" && mode != "latest" && mode != "series" {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid 'mode' parameter '%s', expected 'latest' or 'series'", mode)}
+	}
+	humanize := r.URL.Query().Get("humanize") == "true"
+	smoother, err := parseSmoothing(r)
+	if err != nil {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	if smoother != nil && mode != "series" {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: "'smooth' requires 'mode=series'"}
+	}
 
 	metricQueries := []types.MetricDataQuery{
 		{//for CPU Utilization
@@ -186,9 +703,9 @@ func ec2UsageHandler(w http.ResponseWriter, r *http.Request) {
 				Metric: &types.Metric{
 					Namespace:  aws.String("AWS/EC2"),                                                              // <-- Use aws.String
 					MetricName: aws.String("CPUUtilization"),                                                       // <-- Use aws.String
-					Dimensions: []types.Dimension{{Name: aws.String("InstanceId"), Value: aws.String(instanceID)}}, // <-- Use aws.String
+					Dimensions: dimensions,
 				},
-				Period: aws.Int32(300),        // <-- Use aws.Int32
+				Period: aws.Int32(period),     // <-- Use aws.Int32
 				Stat:   aws.String("Average"), // <-- Use aws.String
 			},
 			ReturnData: aws.Bool(true), // <-- Use aws.Bool
@@ -199,11 +716,9 @@ func ec2UsageHandler(w http.ResponseWriter, r *http.Request) {
 				Metric: &types.Metric{
 					Namespace:  aws.String("CWAgent"),
 					MetricName: aws.String("mem_used_percent"),
-					Dimensions: []types.Dimension{
-						{Name: aws.String("InstanceId"), Value: aws.String(instanceID)},
-					},
+					Dimensions: memDimensions,
 				},
-				Period: aws.Int32(300),
+				Period: aws.Int32(period),
 				Stat:   aws.String("Average"),
 			},
 			ReturnData: aws.Bool(true),
@@ -223,7 +738,7 @@ func ec2UsageHandler(w http.ResponseWriter, r *http.Request) {
 							//{Name: aws.String("fstype"), Value: aws.String("xfs")}, // or "ext4" depending on your AMI
 						},
 					},
-					Period: aws.Int32(300),
+					Period: aws.Int32(period),
 					Stat:   aws.String("Average"),
 				},
 				ReturnData: aws.Bool(true),
@@ -235,9 +750,9 @@ func ec2UsageHandler(w http.ResponseWriter, r *http.Request) {
 				Metric: &types.Metric{
 					Namespace:  aws.String("AWS/EC2"),
 					MetricName: aws.String("NetworkIn"),
-					Dimensions: []types.Dimension{{Name: aws.String("InstanceId"), Value: aws.String(instanceID)}},
+					Dimensions: dimensions,
 				},
-				Period: aws.Int32(300), // <-- Use aws.Int32
+				Period: aws.Int32(period), // <-- Use aws.Int32
 				Stat:   aws.String("Sum"),
 			},
 			ReturnData: aws.Bool(true), // <-- Use aws.Bool
@@ -248,46 +763,220 @@ func ec2UsageHandler(w http.ResponseWriter, r *http.Request) {
 				Metric: &types.Metric{
 					Namespace:  aws.String("AWS/EC2"),
 					MetricName: aws.String("NetworkOut"),
-					Dimensions: []types.Dimension{{Name: aws.String("InstanceId"), Value: aws.String(instanceID)}},
+					Dimensions: dimensions,
 				},
-				Period: aws.Int32(300), // <-- Use aws.Int32
+				Period: aws.Int32(period), // <-- Use aws.Int32
 				Stat:   aws.String("Sum"),
 			},
 			ReturnData: aws.Bool(true), // <-- Use aws.Bool
 		},
 	}
 
-	resp, err := cwClient.GetMetricData(context.TODO(), &cloudwatch.GetMetricDataInput{
+	if asgName != "" {
+		// Current group capacity. Requires group metrics collection to be enabled on the ASG.
+		metricQueries = append(metricQueries, types.MetricDataQuery{
+			Id: aws.String("inService"),
+			MetricStat: &types.MetricStat{
+				Metric: &types.Metric{
+					Namespace:  aws.String("AWS/AutoScaling"),
+					MetricName: aws.String("GroupInServiceInstances"),
+					Dimensions: dimensions,
+				},
+				Period: aws.Int32(60),
+				Stat:   aws.String("Average"),
+			},
+			ReturnData: aws.Bool(true),
+		})
+	}
+	if source == "cwagent" {
+		metricQueries = append(metricQueries, types.MetricDataQuery{
+			Id: aws.String("diskUsed"),
+			MetricStat: &types.MetricStat{
+				Metric: &types.Metric{
+					Namespace:  aws.String("CWAgent"),
+					MetricName: aws.String("disk_used_percent"),
+					Dimensions: cwAgentDiskDimensions(agentDimensions, r),
+				},
+				Period: aws.Int32(period),
+				Stat:   aws.String("Average"),
+			},
+			ReturnData: aws.Bool(true),
+		})
+	}
+
+	// ?stat=Average,Maximum fetches several statistics per metric in the same call.
+	stats, err := parseStats(r)
+	if err != nil {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	if stats != nil {
+		metricQueries, err = expandStats(metricQueries, stats)
+		if err != nil {
+			return nil, &apiError{Status: http.StatusBadRequest, Message: err.Error()}
+		}
+	}
+	if err := checkCardinality(len(metricQueries), startTime, endTime, period); err != nil {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	// ?account= reads from a source account linked through CloudWatch cross-account
+	// observability; without it, queries run against the monitoring account.
+	account, err := parseAccountID(r)
+	if err != nil {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	if account != "" {
+		for i := range metricQueries {
+			metricQueries[i].AccountId = aws.String(account)
+		}
+	}
+
+	scanBy, err := parseScanBy(r, mode)
+	if err != nil {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	labelOptions, err := parseLabelOptions(r)
+	if err != nil {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	input := &cloudwatch.GetMetricDataInput{
 		StartTime:         &startTime,
 		EndTime:           &endTime,
 		MetricDataQueries: metricQueries,
-		ScanBy:            types.ScanByTimestampDescending,
-	})
+		ScanBy:            scanBy,
+		LabelOptions:      labelOptions,
+	}
+	resp, err := getAllMetricData(ctx, input)
 
 	if err != nil {
 		log.Printf("Error getting CloudWatch data: %v", err)
-		http.Error(w, fmt.Sprintf(`{"error": "Error getting CloudWatch data: %v"}`, err), http.StatusInternalServerError)
-		return
+		return nil, upstreamAPIError("Error getting CloudWatch data", err)
+	}
+	// ?debug=raw returns the request and CloudWatch's output as-is (pages merged by
+	// getAllMetricData), in a shape of its own so it is never mistaken for ec2-usage.
+	if r.URL.Query().Get("debug") == "raw" {
+		return map[string]interface{}{"debug": "raw", "request": input, "response": resp}, nil
 	}
 
 	result := make(map[string]interface{})
-	result["InstanceID"] = instanceID // Include instance ID
+	if asgName != "" {
+		result["AutoScalingGroup"] = asgName
+	} else {
+		result["InstanceID"] = publicInstanceID(instance) // Include instance ID
+	}
+	if account != "" {
+		result["AccountId"] = account
+	}
+	// Exactly what was sent to CloudWatch, so charts know their granularity and span.
+	result["period_seconds"] = period
+	result["start"] = startTime.UTC().Format(time.RFC3339)
+	result["end"] = endTime.UTC().Format(time.RFC3339)
 
+	labels := make(map[string]string, len(resp.MetricDataResults))
+	result["labels"] = labels
 	for _, mdr := range resp.MetricDataResults {
 		id := *mdr.Id
+		labels[id] = displayLabel(id)
+		unit := metricUnits[metricBaseID(id)]
+		if strings.HasSuffix(id, "_SampleCount") {
+			unit = "Count"
+		}
+		if unit != "" {
+			result[id+"_unit"] = unit
+		}
+		result[id+"_label"] = metricLabel(mdr)
+		// Network byte sums over a period aren't readable as a rate; add <id>_bps alongside them.
+		bps := isByteSum(id)
+		if len(mdr.Values) == 0 {
+			// The query ran but matched nothing in the window, unlike an empty response.
+			result[id+"_message"] = emptyMetricMessage(id)
+		}
+		if mode == "series" {
+			points := seriesFromResult(mdr)
+			result[id] = points
+			if smoother != nil {
+				result[id+"_smoothed"] = smoothSeries(points, smoother)
+			}
+			if bps {
+				rates := make([]seriesPoint, len(points))
+				for i, p := range points {
+					rates[i] = seriesPoint{Timestamp: p.Timestamp, Value: bitsPerSecond(p.Value, period)}
+				}
+				result[id+"_bps"] = rates
+			}
+			continue
+		}
 		if len(mdr.Values) > 0 {
-			result[id] = mdr.Values[0]
-			result[id+"_Timestamp"] = mdr.Timestamps[0].Format(time.RFC3339) // Use a standard format
+			latest := 0 // newest datapoint comes first unless ?scan=asc was requested
+			if scanBy == types.ScanByTimestampAscending {
+				latest = len(mdr.Values) - 1
+			}
+			result[id] = mdr.Values[latest]
+			result[id+"_Timestamp"] = mdr.Timestamps[latest].Format(time.RFC3339) // Use a standard format
+			if humanize {
+				// Raw numbers stay in result[id]; the formatted string is additive.
+				result[id+"_display"] = humanizeValue(mdr.Values[latest], unit)
+			}
+			if bps {
+				rate := bitsPerSecond(mdr.Values[latest], period)
+				result[id+"_bps"] = rate
+				if humanize {
+					result[id+"_bps_display"] = humanizeValue(rate, "Bits/Second")
+				}
+			}
 		} else {
 			result[id] = "N/A"
 		}
 	}
-	if len(resp.MetricDataResults) == 0 {
+	// Thresholds apply to a metric's ?stat= variants too; severity needs a latest value.
+	thresholds := make(map[string]metricThreshold)
+	severity := make(map[string]string)
+	for _, mdr := range resp.MetricDataResults {
+		id := *mdr.Id
+		threshold, ok := metricThresholds[metricBaseID(id)]
+		if !ok || strings.HasSuffix(id, "_SampleCount") {
+			continue
+		}
+		thresholds[id] = threshold
+		if value, ok := result[id].(float64); ok {
+			severity[id] = threshold.severity(value)
+		}
+	}
+	result["thresholds"] = thresholds
+	result["severity"] = severity
+	// Alarms live in the monitoring account, so cross-account reads aren't annotated.
+	if account == "" {
+		addAlarmAnnotations(ctx, result, metricQueries)
+	}
+	switch {
+	case len(resp.MetricDataResults) == 0:
 		log.Println("CloudWatch GetMetricData returned no results.")
 		result["message"] = "No metric data returned from CloudWatch."
+	case !hasAnyValues(resp.MetricDataResults):
+		// Every query came back empty; <id>_message has the likely cause per metric.
+		result["message"] = "CloudWatch returned no datapoints for any metric in this window."
+	}
+	if source == "cwagent" && !hasValues(resp.MetricDataResults, "memUsed", "diskUsed") {
+		// Absent, not broken: the agent simply isn't publishing for these dimensions.
+		result["cwagentMessage"] = "No CloudWatch agent metrics found for this instance. Check that the agent is running and publishes mem/disk with InstanceId, ImageId and InstanceType dimensions."
+	}
+	// ?diagnose=true checks whether the instance's monitoring level can serve the
+	// requested period. It costs an EC2 DescribeInstances call, so it is opt-in, and
+	// is skipped for ASGs and cross-account reads, where the instance isn't ours to describe.
+	if r.URL.Query().Get("diagnose") == "true" && asgName == "" && account == "" {
+		detailed, err := detailedMonitoringEnabled(ctx, instance)
+		if err != nil {
+			log.Printf("Could not check detailed monitoring for %s: %v", instance, err)
+		} else {
+			result["detailed_monitoring"] = detailed
+			if !detailed && period < 300 {
+				result["monitoringMessage"] = fmt.Sprintf("Detailed monitoring is disabled for %s, so EC2 metrics are only published every 5 minutes and a %ds period may return no data. Use period=300 or enable detailed monitoring.", publicInstanceID(instance), period)
+			}
+		}
 	}
 
-	json.NewEncoder(w).Encode(result)
+	return result, nil
 }
 
 // freeTierUsageHandler fetches EC2 hours and Data Transfer Out for the current month.
@@ -296,7 +985,7 @@ func freeTierUsageHandler(w http.ResponseWriter, r *http.Request) {
     w.Header().Set("Access-Control-Allow-Origin", "*")
 
     if cwClient == nil {
-        http.Error(w, `{"error": "AWS client not initialized"}`, http.StatusInternalServerError)
+        writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "AWS client not initialized")
         return
     }
 
@@ -318,7 +1007,7 @@ func freeTierUsageHandler(w http.ResponseWriter, r *http.Request) {
         Namespace:  aws.String("AWS/EC2"),
         MetricName: aws.String("NetworkOut"),
         Dimensions: []types.Dimension{
-            {Name: aws.String("InstanceId"), Value: aws.String(instanceID)},
+            {Name: aws.String("InstanceId"), Value: aws.String(currentInstanceID())},
         },
         StartTime: &startOfMonth,
         EndTime:   &endOfMonth,
@@ -329,7 +1018,7 @@ func freeTierUsageHandler(w http.ResponseWriter, r *http.Request) {
     }
     netOutResp, err := cwClient.GetMetricStatistics(context.TODO(), netOutInput)
     if err != nil {
-        http.Error(w, fmt.Sprintf(`{"error": "Failed to get NetworkOut: %v"}`, err), http.StatusInternalServerError)
+        writeUpstreamError(w, "Failed to get NetworkOut", err)
         return
     }
     var totalNetOut float64
@@ -359,7 +1048,168 @@ func freeTierUsageHandler(w http.ResponseWriter, r *http.Request) {
         "timestamp":                now.Format(time.RFC3339),
     }
 
-    json.NewEncoder(w).Encode(result)
+    writeJSON(w, r, result)
+}
+
+// albDimensionValue converts a target group or load balancer ARN into the
+// dimension value CloudWatch expects (e.g. "targetgroup/my-tg/abc123").
+// Values that are already in dimension form are returned unchanged.
+func albDimensionValue(arnOrValue, marker string) string {
+	if idx := strings.Index(arnOrValue, ":"+marker); idx >= 0 {
+		value := arnOrValue[idx+1:]
+		if marker == "loadbalancer/" {
+			// Load balancer dimension drops the "loadbalancer/" prefix: "app/my-lb/abc123"
+			value = strings.TrimPrefix(value, marker)
+		}
+		return value
+	}
+	return arnOrValue
+}
+
+// albHealthHandler fetches healthy/unhealthy host counts for an ALB target group.
+func albHealthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if cwClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "AWS client not initialized")
+		return
+	}
+
+	tg := r.URL.Query().Get("tg")
+	if tg == "" {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, "Missing required 'tg' query parameter (target group ARN)")
+		return
+	}
+
+	dimensions := []types.Dimension{
+		{Name: aws.String("TargetGroup"), Value: aws.String(albDimensionValue(tg, "targetgroup/"))},
+	}
+	if lb := r.URL.Query().Get("lb"); lb != "" {
+		dimensions = append(dimensions, types.Dimension{
+			Name: aws.String("LoadBalancer"), Value: aws.String(albDimensionValue(lb, "loadbalancer/")),
+		})
+	}
+
+	endTime := time.Now()
+	startTime := endTime.Add(-10 * time.Minute)
+
+	metricQueries := []types.MetricDataQuery{
+		{//for Healthy hosts (Minimum: the worst moment in the period)
+			Id: aws.String("healthy"),
+			MetricStat: &types.MetricStat{
+				Metric: &types.Metric{
+					Namespace:  aws.String("AWS/ApplicationELB"),
+					MetricName: aws.String("HealthyHostCount"),
+					Dimensions: dimensions,
+				},
+				Period: aws.Int32(60),
+				Stat:   aws.String("Minimum"),
+			},
+			ReturnData: aws.Bool(true),
+		},
+		{//for Unhealthy hosts (Maximum: any unhealthy moment counts)
+			Id: aws.String("unhealthy"),
+			MetricStat: &types.MetricStat{
+				Metric: &types.Metric{
+					Namespace:  aws.String("AWS/ApplicationELB"),
+					MetricName: aws.String("UnHealthyHostCount"),
+					Dimensions: dimensions,
+				},
+				Period: aws.Int32(60),
+				Stat:   aws.String("Maximum"),
+			},
+			ReturnData: aws.Bool(true),
+		},
+	}
+
+	resp, err := cwClient.GetMetricData(context.TODO(), &cloudwatch.GetMetricDataInput{
+		StartTime:         &startTime,
+		EndTime:           &endTime,
+		MetricDataQueries: metricQueries,
+		ScanBy:            types.ScanByTimestampDescending,
+	})
+	if err != nil {
+		log.Printf("Error getting ALB CloudWatch data: %v", err)
+		writeUpstreamError(w, "Error getting ALB CloudWatch data", err)
+		return
+	}
+
+	result := make(map[string]interface{})
+	result["TargetGroup"] = albDimensionValue(tg, "targetgroup/")
+
+	unhealthyCount := 0.0
+	for _, mdr := range resp.MetricDataResults {
+		id := *mdr.Id
+		if len(mdr.Values) > 0 {
+			result[id] = mdr.Values[0]
+			result[id+"_Timestamp"] = mdr.Timestamps[0].Format(time.RFC3339)
+			if id == "unhealthy" {
+				unhealthyCount = mdr.Values[0]
+			}
+		} else {
+			result[id] = "N/A"
+		}
+	}
+	// Flag the target group when any host has been reported unhealthy in the latest period.
+	result["hasUnhealthy"] = unhealthyCount > 0
+
+	writeJSON(w, r, result)
+}
+
+// instanceInfoHandler returns a "where am I running" summary from the EC2 metadata service.
+func instanceInfoHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	fields := map[string]string{
+		"instanceId":       "instance-id",
+		"instanceType":     "instance-type",
+		"availabilityZone": "placement/availability-zone",
+		"amiId":            "ami-id",
+		"localIpv4":        "local-ipv4",
+	}
+
+	result := make(map[string]interface{})
+	for name, path := range fields {
+		value, err := fetchMetadata(path)
+		if err != nil {
+			log.Printf("Instance metadata unavailable: %v", err)
+			writeErrorJSON(w, http.StatusServiceUnavailable, codeUnavailable, "EC2 instance metadata is unavailable. CloudPulse does not appear to be running on EC2.")
+			return
+		}
+		if name == "instanceId" {
+			value = publicInstanceID(value)
+		}
+		result[name] = value
+	}
+
+	writeJSON(w, r, result)
+}
+
+// awsIdentityHandler reports which AWS principal CloudPulse's credentials resolve to,
+// for checking cross-account and assume-role setups.
+func awsIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if stsClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "AWS client not initialized")
+		return
+	}
+
+	identity, err := stsClient.GetCallerIdentity(r.Context(), &sts.GetCallerIdentityInput{})
+	if err != nil {
+		log.Printf("Error getting AWS caller identity: %v", err)
+		writeUpstreamError(w, "Failed to get AWS caller identity", err)
+		return
+	}
+
+	writeJSON(w, r, map[string]string{
+		"account": safeDeref(identity.Account),
+		"arn":     safeDeref(identity.Arn),
+		"userId":  safeDeref(identity.UserId),
+	})
 }
 
 // githubUsersHandler fetches collaborators from a GitHub repository.
@@ -368,20 +1218,34 @@ func githubUsersHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	if githubClient == nil {
-		http.Error(w, `{"error": "GitHub client not initialized"}`, http.StatusInternalServerError)
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "GitHub client not initialized")
 		return
 	}
 
-	users, _, err := githubClient.Repositories.ListCollaborators(
-		context.Background(),
-		githubOwner,
-		githubRepo,
-		&github.ListCollaboratorsOptions{ListOptions: github.ListOptions{PerPage: 100}},
-	)
+	listOpts, err := parsePagination(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := githubContext(r)
+	defer cancel()
+
+	var users []*github.User
+	var ghResp *github.Response
+	err = withRetryBudget(ctx, func() (err error) {
+		users, ghResp, err = githubClient.Repositories.ListCollaborators(
+			ctx,
+			githubOwner,
+			githubRepo,
+			&github.ListCollaboratorsOptions{ListOptions: listOpts},
+		)
+		return err
+	})
 
 	if err != nil {
 		log.Printf("Error getting GitHub users: %v", err)
-		http.Error(w, fmt.Sprintf(`{"error": "Error getting GitHub users: %v"}`, err), http.StatusInternalServerError)
+		writeGitHubError(w, "Error getting GitHub users", err)
 		return
 	}
 
@@ -390,6 +1254,8 @@ func githubUsersHandler(w http.ResponseWriter, r *http.Request) {
 		AvatarURL string `json:"avatar_url"`
 		HTMLURL   string `json:"html_url"`
 		RoleName  string `json:"role_name"`
+		// Permissions always has all five keys, so a missing map from GitHub reads as no access.
+		Permissions map[string]bool `json:"permissions"`
 	}
 
 	var userInfos []UserInfo
@@ -399,10 +1265,18 @@ func githubUsersHandler(w http.ResponseWriter, r *http.Request) {
 			AvatarURL: safeDeref(user.AvatarURL),
 			HTMLURL:   safeDeref(user.HTMLURL),
 			RoleName:  safeDeref(user.RoleName),
+			Permissions: map[string]bool{
+				"admin":    user.Permissions["admin"],
+				"maintain": user.Permissions["maintain"],
+				"push":     user.Permissions["push"],
+				"triage":   user.Permissions["triage"],
+				"pull":     user.Permissions["pull"],
+			},
 		})
 	}
 
-	json.NewEncoder(w).Encode(userInfos)
+	setPaginationHeaders(w, listOpts, ghResp)
+	writeJSON(w, r, userInfos)
 }
 
 // safeDeref safely dereferences a string pointer, returning "" if nil.
@@ -413,13 +1287,362 @@ func safeDeref(s *string) string {
 	return ""
 }
 
+// maxPerPage is GitHub's upper bound on page size for list endpoints.
+const maxPerPage = 100
+
+// parsePagination maps ?page= and ?per_page= onto github.ListOptions.
+// Without parameters it returns page 1 with GITHUB_PER_PAGE items (100 by default).
+func parsePagination(r *http.Request) (github.ListOptions, error) {
+	opts := github.ListOptions{Page: 1, PerPage: githubPerPage}
+
+	if raw := r.URL.Query().Get("page"); raw != "" {
+		page, err := strconv.Atoi(raw)
+		if err != nil || page < 1 {
+			return opts, fmt.Errorf("invalid 'page' parameter '%s', expected a positive integer", raw)
+		}
+		opts.Page = page
+	}
+	if raw := r.URL.Query().Get("per_page"); raw != "" {
+		perPage, err := strconv.Atoi(raw)
+		if err != nil || perPage < 1 {
+			return opts, fmt.Errorf("invalid 'per_page' parameter '%s', expected a positive integer", raw)
+		}
+		if perPage > maxPerPage {
+			perPage = maxPerPage
+		}
+		opts.PerPage = perPage
+	}
+	return opts, nil
+}
+
+// setPaginationHeaders reports pagination state in response headers so list
+// responses keep their existing JSON array shape for current consumers.
+func setPaginationHeaders(w http.ResponseWriter, opts github.ListOptions, resp *github.Response) {
+	w.Header().Set("Access-Control-Expose-Headers", "X-Page, X-Per-Page, X-Has-Next, X-Next-Page")
+	w.Header().Set("X-Page", strconv.Itoa(opts.Page))
+	w.Header().Set("X-Per-Page", strconv.Itoa(opts.PerPage))
+
+	hasNext := resp != nil && resp.NextPage != 0
+	w.Header().Set("X-Has-Next", strconv.FormatBool(hasNext))
+	if hasNext {
+		w.Header().Set("X-Next-Page", strconv.Itoa(resp.NextPage))
+	}
+}
+
+// openAPISpec is the hand-maintained OpenAPI 3 description of the API.
+// Update openapi.json whenever an endpoint, query parameter, or response field changes.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// openAPIHandler serves the OpenAPI document used to generate typed frontend clients.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(openAPISpec)
+}
+
+// configHandler exposes non-sensitive runtime settings the frontend needs.
+// Never add secrets, credentials, tokens, or Vault details here: this endpoint is unauthenticated.
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	writeJSON(w, r, map[string]interface{}{
+		"basePath":            basePath,
+		"defaultWindow":       defaultMetricWindow.String(),
+		"defaultPeriod":       defaultMetricPeriod,
+		"pollIntervalSeconds": int(pollInterval.Seconds()),
+		"githubOwner":         githubOwner,
+		"githubRepo":          githubRepo,
+		"features": map[string]bool{
+			"aws":    cwClient != nil,
+			"github": githubClient != nil,
+		},
+	})
+}
+
+// intFromEnv reads a positive integer from the named env var, falling back to def when unset or invalid.
+func intFromEnv(name string, def int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		log.Printf("Invalid %s '%s', using default of %d.", name, raw, def)
+		return def
+	}
+	return n
+}
+
+// durationFromEnv reads a Go duration (e.g. "30s", "5m") from the named env var,
+// falling back to def when unset or invalid.
+func durationFromEnv(name string, def time.Duration) time.Duration {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid %s '%s', using default of %s.", name, raw, def)
+		return def
+	}
+	return d
+}
+
+// basePathFromEnv reads BASE_PATH and normalizes it to "/prefix" form without a
+// trailing slash. Unset, empty, or "/" all mean the app is served from root.
+func basePathFromEnv() string {
+	p := strings.Trim(os.Getenv("BASE_PATH"), "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// githubContext bounds a single GitHub API call by GITHUB_TIMEOUT so a network
+// stall can't hang the handler. It inherits cancellation from the request.
+func githubContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), githubTimeout)
+}
+
+// isTimeout reports whether err was caused by a deadline or network timeout.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// apiError is an error that carries the HTTP status it should be reported with.
+type apiError struct {
+	Status  int
+	Code    string // machine-readable code; derived from Status when empty
+	Message string
+	Err     error // underlying cause, if any
+}
+
+func (e *apiError) Error() string { return e.Message }
+
+func (e *apiError) Unwrap() error { return e.Err }
+
+// writeAPIError writes err as a JSON error body, using its status when it is an
+// *apiError and 500 otherwise.
+func writeAPIError(w http.ResponseWriter, err error) {
+	status, code := http.StatusInternalServerError, ""
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		status, code = apiErr.Status, apiErr.Code
+	}
+	if code == "" {
+		code = codeForStatus(status)
+	}
+	writeErrorJSON(w, status, code, err.Error())
+}
+
+// writeJSON encodes v into a buffer before writing it, so an encoding failure
+// can still be reported as a clean 500 instead of a truncated 200 body.
+// ?pretty=true indents the output for reading in a browser; compact is the default.
+// ?fields=a,b keeps only those top-level keys (see selectFields). With ENVELOPE=true
+// or ?envelope=true, the result is then wrapped by envelope.
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	encodeJSON(w, r, v, nil)
+}
+
+// writePartialJSON writes result like writeJSON for a response where some parts
+// failed. errs, keyed by what failed (e.g. a namespace or an instance), goes in the
+// envelope's errors, or in result["errors"] for a bare response. Nothing is added
+// when errs is empty.
+func writePartialJSON(w http.ResponseWriter, r *http.Request, result map[string]interface{}, errs map[string]string) {
+	if !wantsEnvelope(r) {
+		if len(errs) > 0 {
+			result["errors"] = errs
+		}
+		encodeJSON(w, r, result, nil)
+		return
+	}
+	targets := make([]string, 0, len(errs))
+	for target := range errs {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	failures := make([]responseError, 0, len(errs))
+	for _, target := range targets {
+		failures = append(failures, responseError{Code: codeUpstreamError, Message: errs[target], Target: target})
+	}
+	encodeJSON(w, r, result, failures)
+}
+
+// encodeJSON is writeJSON, with the failures to report if the response is enveloped.
+func encodeJSON(w http.ResponseWriter, r *http.Request, v interface{}, failures []responseError) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if r.URL.Query().Get("pretty") == "true" {
+		enc.SetIndent("", "  ")
+	}
+	if raw := r.URL.Query().Get("fields"); raw != "" {
+		filtered, err := selectFields(v, strings.Split(raw, ","))
+		if err != nil {
+			log.Printf("Error selecting fields for %s %s: %v", r.Method, r.URL.Path, err)
+			writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "Error encoding response")
+			return
+		}
+		v = filtered
+	}
+	if wantsEnvelope(r) {
+		v = envelope(w, r, v, failures)
+	}
+	if err := enc.Encode(v); err != nil {
+		log.Printf("Error encoding JSON response for %s %s: %v", r.Method, r.URL.Path, err)
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "Error encoding response")
+		return
+	}
+	if _, err := buf.WriteTo(w); err != nil {
+		// Headers are already sent; all that's left is to record it.
+		debugf("Error writing response for %s %s: %v", r.Method, r.URL.Path, err)
+	}
+}
+
+// selectFields reduces v to the named top-level keys. v is first converted to its
+// JSON form, so structs are filtered by their JSON names; for an array, each object
+// element is filtered. Unknown names are ignored, and non-object values pass through.
+func selectFields(v interface{}, fields []string) (interface{}, error) {
+	keep := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		if f = strings.TrimSpace(f); f != "" {
+			keep[f] = true
+		}
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	// UseNumber keeps large integers (IDs, byte counts) exact through the round trip.
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var generic interface{}
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	filter := func(obj map[string]interface{}) {
+		for k := range obj {
+			if !keep[k] {
+				delete(obj, k)
+			}
+		}
+	}
+	switch value := generic.(type) {
+	case map[string]interface{}:
+		filter(value)
+	case []interface{}:
+		for _, elem := range value {
+			if obj, ok := elem.(map[string]interface{}); ok {
+				filter(obj)
+			}
+		}
+	}
+	return generic, nil
+}
+
+// responseEnvelope is the opt-in {data, meta, errors} response shape. A failed
+// request has null data and its error in errors; a partly failed one has both.
+type responseEnvelope struct {
+	Data   interface{}     `json:"data"`
+	Meta   responseMeta    `json:"meta"`
+	Errors []responseError `json:"errors"`
+}
+
+// responseError is one entry in an envelope's errors.
+type responseError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Target  string `json:"target,omitempty"` // what failed in a partial failure, e.g. a namespace
+}
+
+type responseMeta struct {
+	Timestamp string     `json:"timestamp"`
+	Cached    bool       `json:"cached"`               // served from the metric cache (X-Cache HIT or STALE)
+	RequestID string     `json:"request_id,omitempty"` // X-Request-Id from the client or proxy, if any
+	Params    url.Values `json:"params"`
+}
+
+// wantsEnvelope reports whether r's response should be wrapped: ?envelope=true|false
+// overrides the ENVELOPE default so existing clients keep the bare responses.
+func wantsEnvelope(r *http.Request) bool {
+	if raw := r.URL.Query().Get("envelope"); raw != "" {
+		return raw == "true"
+	}
+	return envelopeDefault
+}
+
+// envelope wraps v with metadata about how the response for r was produced, and errs.
+func envelope(w http.ResponseWriter, r *http.Request, v interface{}, errs []responseError) responseEnvelope {
+	params := r.URL.Query()
+	params.Del("envelope")
+	params.Del("pretty")
+	cache := w.Header().Get("X-Cache")
+	if errs == nil {
+		errs = []responseError{}
+	}
+	return responseEnvelope{
+		Data: v,
+		Meta: responseMeta{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Cached:    cache == "HIT" || cache == "STALE",
+			RequestID: r.Header.Get("X-Request-Id"),
+			Params:    params,
+		},
+		Errors: errs,
+	}
+}
+
+// debugf logs only when LOG_LEVEL=debug.
+func debugf(format string, args ...interface{}) {
+	if debugLogging {
+		log.Printf("DEBUG: "+format, args...)
+	}
+}
+
+// writeGitHubError reports a failed GitHub call: 429 (with Retry-After) when GitHub's
+// secondary rate limit was hit, 504 when it timed out, otherwise as classified by
+// classifyUpstreamError. GitHub error responses also carry GitHub's own status,
+// message and documentation URL (see writeGitHubErrorResponse).
+func writeGitHubError(w http.ResponseWriter, msg string, err error) {
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		retryAfter := time.Minute // GitHub's documented fallback when no Retry-After is sent
+		if abuseErr.RetryAfter != nil {
+			retryAfter = *abuseErr.RetryAfter
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Round(time.Second).Seconds())))
+		writeErrorJSON(w, http.StatusTooManyRequests, codeRateLimited, fmt.Sprintf("%s: GitHub secondary rate limit exceeded, retry after %s", msg, retryAfter.Round(time.Second)))
+		return
+	}
+	if isTimeout(err) {
+		writeErrorJSON(w, http.StatusGatewayTimeout, codeTimeout, fmt.Sprintf("%s: GitHub did not respond within %s", msg, githubTimeout))
+		return
+	}
+	var ghErr *github.ErrorResponse
+	if errors.As(err, &ghErr) {
+		writeGitHubErrorResponse(w, msg, ghErr)
+		return
+	}
+	writeUpstreamError(w, msg, err)
+}
+
 // --- Main Application ---
 
 func main() {
 	log.Println("Starting CloudPulse Backend v3 (Corrected)...")
+	debugLogging = os.Getenv("LOG_LEVEL") == "debug"
 
 	if err := initVault(); err != nil {
-		log.Fatalf("FATAL: Failed to initialize Vault: %v", err)
+		// Vault is only one of the GitHub token sources; see resolveGitHubToken.
+		log.Printf("WARNING: Vault not available, continuing without it: %v", err)
 	}
 	if err := initAWS(); err != nil {
 		log.Fatalf("FATAL: Failed to initialize AWS SDK: %v", err)
@@ -428,12 +1651,70 @@ func main() {
 		log.Fatalf("FATAL: Failed to initialize GitHub client: %v", err)
 	}
 
-	fs := http.FileServer(http.Dir("./frontend"))
-	http.Handle("/", fs)
+	if err := loadSavedViews(); err != nil {
+		log.Fatalf("FATAL: Failed to load saved views: %v", err)
+	}
+	metricCacheTTL = durationFromEnv("METRIC_CACHE_TTL", metricCacheTTL)
+	cacheSWR = os.Getenv("CACHE_MODE") == "swr"
+	maxResponsePoints = intFromEnv("MAX_RESPONSE_POINTS", defaultMaxResponsePoints)
+	envelopeDefault = os.Getenv("ENVELOPE") == "true"
+	pollJitter = durationFromEnv("POLL_JITTER", 0)
+	basePath = basePathFromEnv()
+	pollInterval = durationFromEnv("POLL_INTERVAL", 5*time.Minute)
+	initResponseCache()
+	if basePath != "" {
+		log.Printf("Serving under base path: %s", basePath)
+	}
+
+	// Static files and API routes are both mounted under basePath ("" means root).
+	frontendDir := os.Getenv("FRONTEND_DIR")
+	if frontendDir == "" {
+		frontendDir = "./frontend"
+	}
+	fs := frontendHandler(frontendDir)
+	http.Handle(basePath+"/", http.StripPrefix(basePath, fs))
 
-	http.HandleFunc("/api/ec2-usage", ec2UsageHandler)
-	http.HandleFunc("/api/github-users", githubUsersHandler)
-	http.HandleFunc("/api/free-tier-usage", freeTierUsageHandler)
+	handleAPI("ec2-usage", ec2UsageHandler)
+	handleAPI("github-users", githubUsersHandler)
+	handleAPI("free-tier-usage", freeTierUsageHandler)
+	handleAPI("alb-health", albHealthHandler)
+	handleAPI("github-compare", githubCompareHandler)
+	handleAPI("config", configHandler)
+	handleAPI("nat-usage", natUsageHandler)
+	handleAPI("instance-info", instanceInfoHandler)
+	handleAPI("github-stargazers-timeline", githubStargazersTimelineHandler)
+	handleAPI("openapi.json", openAPIHandler)
+	handleAPI("github-releases", githubReleasesHandler)
+	handleAPI("github-commit-status", githubCommitStatusHandler)
+	handleAPI("ec2-idle", ec2IdleHandler)
+	handleAPI("ec2-usage.prom", ec2UsagePromHandler)
+	handleAPI("cw-dashboards", cwDashboardsHandler)
+	handleAPI("cw-dashboard", cwDashboardHandler)
+	handleAPI("github-teams", githubTeamsHandler)
+	handleAPI("github-traffic", githubTrafficHandler)
+	handleAPI("custom-metric", customMetricHandler)
+	handleAPI("github-me", githubMeHandler)
+	handleAPI("ecs-usage", ecsUsageHandler)
+	handleAPI("permissions-check", permissionsCheckHandler)
+	handleAPI("view", viewHandler)
+	handleAPI("aws-identity", awsIdentityHandler)
+	handleAPI("github-milestones", githubMilestonesHandler)
+	handleAPI("github-commit", githubCommitHandler)
+	handleAPI("ec2-trend", ec2TrendHandler)
+	handleAPI("github-webhooks", githubWebhooksHandler)
+	handleAPI("github-collaborator-changes", githubCollaboratorChangesHandler)
+	handleAPI("cache/flush", cacheFlushHandler)
+	handleAPI("deploy-readiness", deployReadinessHandler)
+	handleAPI("multi-region-overview", multiRegionOverviewHandler)
+	handleAPI("github-security", githubSecurityHandler)
+	handleAPI("instance-id/refresh", instanceIDRefreshHandler)
+	handleAPI("budgets", budgetsHandler)
+	handleAPI("targets", targetsHandler)
+	handleAPI("github-actions-usage", githubActionsUsageHandler)
+	handleAPI("snapshot", snapshotHandler)
+	handleAPI("snapshots", snapshotsHandler)
+	http.HandleFunc(basePath+"/readyz", readyzHandler)
+	logEndpointConfig()
 	/*http.HandleFunc("/api/free-tier-usage", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -447,11 +1728,57 @@ func main() {
 		port = "8080"
 	}
 
-	log.Printf("Server listening on :%s...", port)
-	err := http.ListenAndServe(":"+port, nil)
-	if err != nil {
+	// ctx is cancelled on SIGINT/SIGTERM so background work and the server stop cleanly.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if os.Getenv("PREWARM_INTERVAL") != "" {
+		startPrewarmer(ctx, durationFromEnv("PREWARM_INTERVAL", ec2CacheTTL()/2), os.Getenv("PREWARM_QUERY"))
+	}
+
+	if os.Getenv("INSTANCE_ID_RECHECK_INTERVAL") != "" {
+		startInstanceReconciler(ctx, durationFromEnv("INSTANCE_ID_RECHECK_INTERVAL", 5*time.Minute))
+	}
+
+	// Alerts are opt-in: they only run when SLACK_WEBHOOK_URL or ALERT_WEBHOOK_URL is set.
+	if hook, ok := alertWebhookFromEnv(); ok {
+		startAlerter(ctx, hook, durationFromEnv("ALERT_INTERVAL", time.Minute), durationFromEnv("ALERT_COOLDOWN", 30*time.Minute))
+	}
+
+	handler := buildMiddlewareStack(http.DefaultServeMux)
+	server := &http.Server{Addr: ":" + port, Handler: handler}
+	// Shutdown doesn't interrupt active connections, so end long-lived streams first.
+	streamDrain := durationFromEnv("SHUTDOWN_STREAM_DRAIN", defaultStreamDrain)
+	server.RegisterOnShutdown(func() { activeStreams.CloseAll(streamDrain) })
+
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down server...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Server shutdown error: %v", err)
+		}
+	}()
+
+	// TLS is enabled by providing both TLS_CERT_FILE and TLS_KEY_FILE.
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	var err error
+	if certFile != "" && keyFile != "" {
+		server.TLSConfig, err = tlsConfigFromEnv()
+		if err != nil {
+			log.Fatalf("FATAL: Invalid TLS configuration: %v", err)
+		}
+		log.Printf("Server listening with TLS on :%s...", port)
+		err = server.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		log.Printf("Server listening on :%s...", port)
+		err = server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("FATAL: Server failed to start: %v", err)
 	}
+	log.Println("Server stopped.")
 }
 
 // finally the application is ready to run
\ No newline at end of file
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/smithy-go"
+)
+
+// fakeCloudWatch is a CloudWatchAPI that returns canned GetMetricData results.
+// Methods the tests don't expect to be called panic through the nil embedded client.
+type fakeCloudWatch struct {
+	CloudWatchAPI
+	results []types.MetricDataResult
+	err     error
+}
+
+func (f *fakeCloudWatch) GetMetricData(ctx context.Context, params *cloudwatch.GetMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricDataOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &cloudwatch.GetMetricDataOutput{MetricDataResults: f.results}, nil
+}
+
+func (f *fakeCloudWatch) DescribeAlarms(ctx context.Context, params *cloudwatch.DescribeAlarmsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.DescribeAlarmsOutput, error) {
+	return &cloudwatch.DescribeAlarmsOutput{}, nil
+}
+
+// useFakeCloudWatch points the handlers at fake for the rest of the test, with a
+// monitored instance and an empty cache.
+func useFakeCloudWatch(t *testing.T, fake *fakeCloudWatch) {
+	t.Helper()
+	prevClient, prevCache, prevInstance := cwClient, responseCache, currentInstanceID()
+	cwClient = fake
+	responseCache = newMemoryCache()
+	instanceMu.Lock()
+	instanceID = "i-0123456789abcdef0"
+	instanceMu.Unlock()
+	t.Cleanup(func() {
+		cwClient, responseCache = prevClient, prevCache
+		instanceMu.Lock()
+		instanceID = prevInstance
+		instanceMu.Unlock()
+	})
+}
+
+// metricResult is a GetMetricData result for id with the given values, newest first.
+func metricResult(id string, values ...float64) types.MetricDataResult {
+	timestamps := make([]time.Time, len(values))
+	for i := range values {
+		timestamps[i] = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC).Add(-time.Duration(i) * 5 * time.Minute)
+	}
+	return types.MetricDataResult{Id: aws.String(id), Values: values, Timestamps: timestamps, StatusCode: types.StatusCodeComplete}
+}
+
+// getEC2Usage calls ec2UsageHandler and decodes its JSON body.
+func getEC2Usage(t *testing.T, query string) (int, map[string]interface{}) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	ec2UsageHandler(w, httptest.NewRequest(http.MethodGet, "/api/ec2-usage"+query, nil))
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response is not JSON: %v\n%s", err, w.Body.String())
+	}
+	return w.Code, body
+}
+
+func TestEC2UsageNoData(t *testing.T) {
+	useFakeCloudWatch(t, &fakeCloudWatch{results: []types.MetricDataResult{
+		metricResult("cpu"), metricResult("memUsed"), metricResult("netIn"), metricResult("netOut"),
+	}})
+
+	status, body := getEC2Usage(t, "")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200; body %v", status, body)
+	}
+	if body["message"] != "CloudWatch returned no datapoints for any metric in this window." {
+		t.Errorf("message = %v", body["message"])
+	}
+	for _, id := range []string{"cpu", "memUsed", "netIn", "netOut"} {
+		if body[id] != "N/A" {
+			t.Errorf("%s = %v, want N/A", id, body[id])
+		}
+		if msg, _ := body[id+"_message"].(string); msg == "" {
+			t.Errorf("%s_message missing", id)
+		}
+	}
+}
+
+func TestEC2UsagePartialData(t *testing.T) {
+	useFakeCloudWatch(t, &fakeCloudWatch{results: []types.MetricDataResult{
+		metricResult("cpu", 42.5, 40), metricResult("memUsed"), metricResult("netIn", 1000), metricResult("netOut"),
+	}})
+
+	status, body := getEC2Usage(t, "")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200; body %v", status, body)
+	}
+	if _, ok := body["message"]; ok {
+		t.Errorf("message = %v, want none when some metrics have data", body["message"])
+	}
+	if body["cpu"] != 42.5 {
+		t.Errorf("cpu = %v, want the newest value 42.5", body["cpu"])
+	}
+	if body["cpu_Timestamp"] != "2024-01-01T12:00:00Z" {
+		t.Errorf("cpu_Timestamp = %v", body["cpu_Timestamp"])
+	}
+	if _, ok := body["cpu_message"]; ok {
+		t.Errorf("cpu_message = %v, want none for a metric with data", body["cpu_message"])
+	}
+	if body["memUsed"] != "N/A" {
+		t.Errorf("memUsed = %v, want N/A", body["memUsed"])
+	}
+	if msg, _ := body["memUsed_message"].(string); msg == "" {
+		t.Error("memUsed_message missing")
+	}
+	if body["InstanceID"] != "i-0123456789abcdef0" {
+		t.Errorf("InstanceID = %v", body["InstanceID"])
+	}
+}
+
+func TestEC2UsageGetMetricDataError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{"throttled", &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}, http.StatusTooManyRequests, codeRateLimited},
+		{"access denied", &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "not authorized"}, http.StatusForbidden, codeForbidden},
+		{"unclassified", &smithy.GenericAPIError{Code: "InternalFailure", Message: "boom"}, http.StatusInternalServerError, codeUpstreamError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeCloudWatch(t, &fakeCloudWatch{err: tt.err})
+
+			status, body := getEC2Usage(t, "")
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if body["code"] != tt.wantCode {
+				t.Errorf("code = %v, want %s", body["code"], tt.wantCode)
+			}
+			if msg, _ := body["error"].(string); !strings.HasPrefix(msg, "Error getting CloudWatch data: ") {
+				t.Errorf("error = %q", msg)
+			}
+		})
+	}
+}
+
+func TestSplitSecretPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		wantErr string
+	}{
+		{"", "secret path is empty, expected 'mount/path'"},
+		{"/kv/cloudpulse", "invalid secret path '/kv/cloudpulse': must not start with '/', expected 'mount/path'"},
+		{"kv/cloudpulse/", "invalid secret path 'kv/cloudpulse/': must not end with '/', expected 'mount/path'"},
+		{"cloudpulse", "invalid secret path 'cloudpulse': missing mount, expected 'mount/path'"},
+		{"//", "invalid secret path '//': must not start with '/', expected 'mount/path'"},
+		{"kv//cloudpulse", "invalid secret path 'kv//cloudpulse': empty path segment, expected 'mount/path'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			mount, path, err := splitSecretPath(tt.path)
+			if err == nil {
+				t.Fatalf("splitSecretPath(%q) = %q, %q, want error %q", tt.path, mount, path, tt.wantErr)
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("error = %q, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	mount, path, err := splitSecretPath("kv/cloudpulse")
+	if err != nil || mount != "kv" || path != "cloudpulse" {
+		t.Errorf(`splitSecretPath("kv/cloudpulse") = %q, %q, %v, want "kv", "cloudpulse", nil`, mount, path, err)
+	}
+}
+
+func TestEnvelopeWrapsErrors(t *testing.T) {
+	useFakeCloudWatch(t, &fakeCloudWatch{})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, `/api/ec2-usage?envelope=true&format=x"y`, nil)
+	markEnvelope(http.HandlerFunc(ec2UsageHandler)).ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+	var body responseEnvelope
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response is not JSON: %v\n%s", err, w.Body.String())
+	}
+	if body.Data != nil {
+		t.Errorf("data = %v, want null", body.Data)
+	}
+	want := responseError{Code: codeInvalidRequest, Message: `Invalid 'format' parameter 'x"y', expected 'json' or 'csv'`}
+	if len(body.Errors) != 1 || body.Errors[0] != want {
+		t.Errorf("errors = %+v, want [%+v]", body.Errors, want)
+	}
+}
+
+func TestWritePartialJSON(t *testing.T) {
+	errs := map[string]string{"AWS/RDS": "Error listing CloudWatch metrics: denied"}
+
+	w := httptest.NewRecorder()
+	writePartialJSON(w, httptest.NewRequest(http.MethodGet, "/api/targets", nil), map[string]interface{}{"targets": 1}, errs)
+	var bare map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &bare); err != nil {
+		t.Fatal(err)
+	}
+	if got := bare["errors"].(map[string]interface{})["AWS/RDS"]; got != errs["AWS/RDS"] {
+		t.Errorf("bare errors[AWS/RDS] = %v", got)
+	}
+
+	w = httptest.NewRecorder()
+	writePartialJSON(w, httptest.NewRequest(http.MethodGet, "/api/targets?envelope=true", nil), map[string]interface{}{"targets": 1}, errs)
+	var enveloped responseEnvelope
+	if err := json.Unmarshal(w.Body.Bytes(), &enveloped); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := enveloped.Data.(map[string]interface{})["errors"]; ok {
+		t.Error("enveloped data still has errors")
+	}
+	want := responseError{Code: codeUpstreamError, Message: errs["AWS/RDS"], Target: "AWS/RDS"}
+	if len(enveloped.Errors) != 1 || enveloped.Errors[0] != want {
+		t.Errorf("errors = %+v, want [%+v]", enveloped.Errors, want)
+	}
+}
+
+func TestSnapshotMaskedInstance(t *testing.T) {
+	useFakeCloudWatch(t, &fakeCloudWatch{results: []types.MetricDataResult{metricResult("cpu", 10)}})
+	prevMask, prevRegions := maskInstanceIDs, regionInstances
+	maskInstanceIDs = true
+	regionInstances = map[string][]string{awsConfig.Region: {"i-0fedcba9876543210"}}
+	t.Cleanup(func() { maskInstanceIDs, regionInstances = prevMask, prevRegions })
+	path := filepath.Join(t.TempDir(), "snapshots.ndjson")
+	t.Setenv("SNAPSHOT_FILE", path)
+
+	w := httptest.NewRecorder()
+	snapshotHandler(w, httptest.NewRequest(http.MethodPost, "/api/snapshot", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200; body %s", w.Code, w.Body.String())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("snapshot has %d lines, want 2:\n%s", len(lines), data)
+	}
+	for _, raw := range lines {
+		var line snapshotLine
+		if err := json.Unmarshal([]byte(raw), &line); err != nil {
+			t.Fatal(err)
+		}
+		if line.Metrics["InstanceID"] != line.Target {
+			t.Errorf("snapshot of %s has metrics of %v", line.Target, line.Metrics["InstanceID"])
+		}
+	}
+}
+
+func TestEC2TargetsMaskedInstance(t *testing.T) {
+	useFakeCloudWatch(t, &fakeCloudWatch{})
+	prevMask, prevRegions := maskInstanceIDs, regionInstances
+	maskInstanceIDs = true
+	regionInstances = map[string][]string{awsConfig.Region: {"i-0fedcba9876543210"}}
+	t.Cleanup(func() { maskInstanceIDs, regionInstances = prevMask, prevRegions })
+
+	targets := ec2Targets()
+	if len(targets) != 2 {
+		t.Fatalf("ec2Targets() = %d targets, want 2", len(targets))
+	}
+	if monitored := targets[0]; !monitored.Queryable || monitored.Endpoint == "" || len(monitored.Params) != 0 {
+		t.Errorf("monitored instance = %+v, want queryable without params", monitored)
+	}
+	if masked := targets[1]; masked.Queryable || masked.Endpoint != "" || masked.Params != nil {
+		t.Errorf("masked instance = %+v, want not queryable, without endpoint or params", masked)
+	}
+}
+
+func TestEC2CacheKeyFollowsInstanceChange(t *testing.T) {
+	useFakeCloudWatch(t, &fakeCloudWatch{results: []types.MetricDataResult{metricResult("cpu", 10)}})
+
+	if _, body := getEC2Usage(t, ""); body["InstanceID"] != "i-0123456789abcdef0" {
+		t.Fatalf("InstanceID = %v", body["InstanceID"])
+	}
+	instanceMu.Lock()
+	instanceID = "i-0fedcba9876543210"
+	instanceMu.Unlock()
+	if _, body := getEC2Usage(t, ""); body["InstanceID"] != "i-0fedcba9876543210" {
+		t.Errorf("after an instance change, InstanceID = %v, want the new instance rather than the cached one", body["InstanceID"])
+	}
+}
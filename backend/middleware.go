@@ -0,0 +1,281 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// --- Middleware ---
+
+// Middleware wraps an http.Handler with a cross-cutting concern.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h with middlewares so that the first one listed is the outermost:
+// Chain(h, a, b) serves a request as a(b(h)).
+func Chain(h http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// buildMiddlewareStack is the single place where the server's middleware order is
+// decided. The mux is wrapped once, outermost first:
+//
+//  1. recoverPanics – must be outermost so a panic anywhere below still yields a 500.
+//  2. markEnvelope – before anything that can reject a request, so those errors are
+//     enveloped too.
+//  3. request shaping (concurrency caps, body limits, and later rate limiting) – reject
+//     bad or excessive requests before doing any real work.
+//  4. auth – runs after shaping but before any handler sees the request.
+//
+// New middleware should be slotted into this list rather than wrapped ad hoc in main.
+func buildMiddlewareStack(mux http.Handler) http.Handler {
+	apiLimit := intFromEnv("MAX_INFLIGHT_REQUESTS", 0)
+	return Chain(mux,
+		recoverPanics,
+		markEnvelope,
+		limitInflight(apiLimit, intFromEnv("MAX_INFLIGHT_STATIC", 4*apiLimit)),
+		limitRequestBody(maxRequestBodyFromEnv()),
+		requireAPIKey(os.Getenv("API_KEY")),
+	)
+}
+
+// recoverPanics turns a panicking handler into a logged 500 instead of a dropped connection.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				log.Printf("PANIC serving %s %s for %s: %v", r.Method, r.URL.Path, clientIP(r), rec)
+				w.Header().Set("Content-Type", "application/json")
+				writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "Internal server error")
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// defaultMaxRequestBody is the request body limit used when MAX_REQUEST_BODY is unset (1MB).
+const defaultMaxRequestBody int64 = 1 << 20
+
+// envelopeWriter marks an API response that should use the {data, meta, errors}
+// envelope, and carries the request so writeErrorJSON, which only gets the writer,
+// can build it.
+type envelopeWriter struct {
+	http.ResponseWriter
+	r *http.Request
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *envelopeWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// markEnvelope wraps the writer of API requests that want the envelope (see
+// wantsEnvelope) in an envelopeWriter. Successful responses are enveloped by
+// writeJSON from the request alone; this is for the error paths.
+func markEnvelope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, basePath+"/api/") && wantsEnvelope(r) {
+			w = &envelopeWriter{ResponseWriter: w, r: r}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maxRequestBodyFromEnv reads MAX_REQUEST_BODY (in bytes), falling back to the default.
+func maxRequestBodyFromEnv() int64 {
+	raw := os.Getenv("MAX_REQUEST_BODY")
+	if raw == "" {
+		return defaultMaxRequestBody
+	}
+	limit, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || limit <= 0 {
+		log.Printf("Invalid MAX_REQUEST_BODY '%s', using default of %d bytes.", raw, defaultMaxRequestBody)
+		return defaultMaxRequestBody
+	}
+	return limit
+}
+
+// limitRequestBody bounds the body of non-GET requests to maxBytes. Requests that
+// declare a larger Content-Length are rejected up front with 413; otherwise the
+// body is wrapped in http.MaxBytesReader so any later read fails once the limit is hit.
+func limitRequestBody(maxBytes int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.ContentLength > maxBytes {
+				w.Header().Set("Content-Type", "application/json")
+				writeErrorJSON(w, http.StatusRequestEntityTooLarge, codeInvalidRequest, fmt.Sprintf("Request body exceeds limit of %d bytes", maxBytes))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isBodyTooLarge reports whether err came from reading past a MaxBytesReader limit,
+// so handlers reading a body can answer with 413 instead of a generic 400.
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// limitInflight caps concurrent requests: API requests (under basePath+"/api/") share
+// apiLimit slots and everything else (the static UI) shares staticLimit, so the UI
+// stays usable while the API is saturated. A limit of 0 disables that cap. Requests
+// over the limit get an immediate 503 with Retry-After rather than queueing.
+func limitInflight(apiLimit, staticLimit int) Middleware {
+	newSlots := func(n int) chan struct{} {
+		if n <= 0 {
+			return nil
+		}
+		return make(chan struct{}, n)
+	}
+	apiSlots, staticSlots := newSlots(apiLimit), newSlots(staticLimit)
+	if apiSlots != nil || staticSlots != nil {
+		log.Printf("Limiting in-flight requests: api=%d static=%d (0 = unlimited)", apiLimit, staticLimit)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			slots := staticSlots
+			if strings.HasPrefix(r.URL.Path, basePath+"/api/") {
+				slots = apiSlots
+			}
+			if slots == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+				next.ServeHTTP(w, r)
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Retry-After", "1")
+				writeErrorJSON(w, http.StatusServiceUnavailable, codeUnavailable, "Server is busy, please retry shortly")
+			}
+		})
+	}
+}
+
+// apiKeyPaths are the routes (relative to basePath) that change server state and
+// therefore require the API key. Everything else stays public and read-only.
+var apiKeyPaths = map[string]bool{
+	"/api/cache/flush":         true,
+	"/api/instance-id/refresh": true,
+	"/api/snapshot":            true,
+}
+
+// apiKeyRequired reports whether r needs the API key: it targets one of apiKeyPaths,
+// or asks for raw upstream output with ?debug=raw.
+func apiKeyRequired(r *http.Request) bool {
+	return apiKeyPaths[strings.TrimPrefix(r.URL.Path, basePath)] || r.URL.Query().Get("debug") == "raw"
+}
+
+// requireAPIKey rejects requests that need the API key (see apiKeyRequired) unless
+// the X-API-Key header matches key. With no key configured they are disabled
+// rather than left open.
+func requireAPIKey(key string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !apiKeyRequired(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if key == "" {
+				writeErrorJSON(w, http.StatusForbidden, codeForbidden, "This endpoint is disabled because API_KEY is not set")
+				return
+			}
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-API-Key")), []byte(key)) != 1 {
+				log.Printf("Rejected %s %s from %s: missing or invalid API key", r.Method, r.URL.Path, clientIP(r))
+				writeErrorJSON(w, http.StatusUnauthorized, codeUnauthorized, "Missing or invalid X-API-Key header")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// trustedProxies are the peers (TRUSTED_PROXIES) whose X-Forwarded-For and
+// X-Real-IP headers clientIP believes, e.g. the load balancer's subnet.
+var trustedProxies = trustedProxiesFromEnv()
+
+// trustedProxiesFromEnv parses TRUSTED_PROXIES, a comma-separated list of CIDRs or
+// single IPs such as "10.0.0.0/16,192.168.1.5". Invalid entries are skipped.
+func trustedProxiesFromEnv() []*net.IPNet {
+	var nets []*net.IPNet
+	raw := os.Getenv("TRUSTED_PROXIES")
+	if raw == "" {
+		return nets
+	}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Ignoring invalid TRUSTED_PROXIES entry %q, expected a CIDR or IP", entry)
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+// isTrustedProxy reports whether ip falls inside TRUSTED_PROXIES.
+func isTrustedProxy(ip net.IP) bool {
+	for _, ipNet := range trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client behind r. Forwarding headers are only
+// honoured when the direct peer is a trusted proxy, so clients can't spoof them:
+// X-Forwarded-For is read right to left, skipping further trusted proxies, and
+// X-Real-IP is the fallback. Otherwise it is the peer address from RemoteAddr.
+func clientIP(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	peerIP := net.ParseIP(peer)
+	if peerIP == nil || !isTrustedProxy(peerIP) {
+		return peer
+	}
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break
+			}
+			if i == 0 || !isTrustedProxy(ip) {
+				return ip.String()
+			}
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return peer
+}
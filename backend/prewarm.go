@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// --- Cache Pre-Warmer ---
+
+// maxPrewarmBackoff bounds how far the pre-warm interval stretches while CloudWatch is throttling.
+const maxPrewarmBackoff = 8
+
+// startPrewarmer refreshes the EC2 metric cache entry for query (a raw query
+// string such as "range=1h&mode=series"; empty means the default view) every
+// interval, so a wall-mounted dashboard is almost always served warm. Set the
+// interval below METRIC_CACHE_TTL. It stops when ctx is cancelled and backs off
+// exponentially while CloudWatch is throttling.
+func startPrewarmer(ctx context.Context, interval time.Duration, query string) {
+	log.Printf("Starting EC2 metric cache pre-warmer every %s (query: '%s').", interval, query)
+
+	go func() {
+		delay := time.Duration(0) // warm immediately on startup
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("EC2 metric cache pre-warmer stopped.")
+				return
+			case <-time.After(delay):
+			}
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/api/ec2-usage?"+query, nil)
+			if err != nil {
+				log.Printf("Invalid PREWARM_QUERY '%s': %v. Pre-warmer disabled.", query, err)
+				return
+			}
+
+			start := time.Now()
+			result, err := fetchEC2Usage(ctx, req)
+			switch {
+			case err != nil && ctx.Err() != nil:
+				continue // shutting down; the select above returns
+			case err != nil && isThrottling(err):
+				delay *= 2
+				if delay < interval {
+					delay = interval
+				}
+				if delay > interval*maxPrewarmBackoff {
+					delay = interval * maxPrewarmBackoff
+				}
+				log.Printf("CloudWatch is throttling the pre-warmer, backing off to %s.", delay)
+			case err != nil:
+				log.Printf("EC2 metric cache pre-warm failed: %v", err)
+				delay = interval
+			default:
+				responseCache.Set(ec2CacheKey(req), result, ec2CacheTTL())
+				debugf("Pre-warmed EC2 metric cache in %s.", time.Since(start))
+				delay = interval
+			}
+		}
+	}()
+}
+
+// isThrottling reports whether err is an AWS throttling / rate-limit error.
+func isThrottling(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequestsException", "RequestThrottled":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Redis Cache ---
+
+// redisTimeout bounds each cache round trip; a slow Redis should cost a cache miss,
+// not a slow response.
+const redisTimeout = 500 * time.Millisecond
+
+// redisRefreshLock is how long a StartRefresh mark lives if FinishRefresh never
+// runs, e.g. because the replica holding it died.
+const redisRefreshLock = time.Minute
+
+func init() {
+	// Cached values are stored as interface{}, so gob needs every concrete type
+	// that appears in them registered up front.
+	gob.Register(map[string]interface{}{})
+	gob.Register(map[string]string{})
+	gob.Register(map[string]metricThreshold{})
+	gob.Register([]seriesPoint{})
+	gob.Register([][]string{})
+	gob.Register([]BudgetInfo{})
+	gob.Register([]starWeek{})
+}
+
+// redisEntry is how a cached value is stored in Redis.
+type redisEntry struct {
+	Value   interface{}
+	Expires time.Time // when the value stops being fresh
+}
+
+// redisCache is a cache backed by Redis, so replicas share results instead of each
+// querying CloudWatch and GitHub on its own. Values are gob-encoded under
+// "<prefix>entry:<key>" and expire through Redis. Errors are logged and treated as
+// misses: Redis being down makes CloudPulse slower, not broken.
+type redisCache struct {
+	client *redis.Client
+	prefix string
+	// keepStale (CACHE_MODE=swr) has Redis keep each entry for a second TTL after it
+	// expires, so stale-while-revalidate has something to serve.
+	keepStale bool
+}
+
+// initResponseCache switches responseCache to Redis when REDIS_ADDR is set, as
+// host:port or a redis:// or rediss:// URL (which may carry a password and DB).
+// Keys are namespaced with REDIS_KEY_PREFIX (default "cloudpulse:"). Without
+// REDIS_ADDR, the in-memory cache stays in place.
+func initResponseCache() {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		return
+	}
+	opts := &redis.Options{Addr: addr}
+	if strings.Contains(addr, "://") {
+		parsed, err := redis.ParseURL(addr)
+		if err != nil {
+			log.Printf("Invalid REDIS_ADDR, using the in-memory cache: %v", err)
+			return
+		}
+		opts = parsed
+	}
+	opts.DialTimeout, opts.ReadTimeout, opts.WriteTimeout = redisTimeout, redisTimeout, redisTimeout
+
+	prefix := os.Getenv("REDIS_KEY_PREFIX")
+	if prefix == "" {
+		prefix = "cloudpulse:"
+	}
+	c := &redisCache{client: redis.NewClient(opts), prefix: prefix, keepStale: cacheSWR}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.client.Ping(ctx).Err(); err != nil {
+		// Keep Redis anyway: it may simply not be up yet, and errors only cost misses.
+		log.Printf("WARNING: Redis at %s is not reachable yet: %v", opts.Addr, err)
+	}
+	responseCache = c
+	log.Printf("Using Redis response cache at %s (key prefix %q)", opts.Addr, prefix)
+}
+
+// entryKey is the Redis key holding key's value.
+func (c *redisCache) entryKey(key string) string {
+	return c.prefix + "entry:" + key
+}
+
+// load fetches and decodes key's entry. A missing key is not an error.
+func (c *redisCache) load(key string) (redisEntry, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	var entry redisEntry
+	data, err := c.client.Get(ctx, c.entryKey(key)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("Redis cache read of %s failed: %v", key, err)
+		}
+		return entry, false
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		log.Printf("Redis cache entry %s could not be decoded: %v", key, err)
+		return entry, false
+	}
+	return entry, true
+}
+
+// Get returns the value for key if present and not expired.
+func (c *redisCache) Get(key string) (interface{}, bool) {
+	entry, ok := c.load(key)
+	if !ok || time.Now().After(entry.Expires) {
+		return nil, false
+	}
+	return entry.Value, true
+}
+
+// GetStale returns the value for key even if it has expired, for as long as Redis
+// still holds it (see keepStale).
+func (c *redisCache) GetStale(key string) (interface{}, bool) {
+	entry, ok := c.load(key)
+	if !ok {
+		return nil, false
+	}
+	return entry.Value, true
+}
+
+// Remaining returns how long key stays fresh, or 0 if it is missing or expired.
+func (c *redisCache) Remaining(key string) time.Duration {
+	entry, ok := c.load(key)
+	if !ok {
+		return 0
+	}
+	if left := time.Until(entry.Expires); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// StartRefresh marks key as being refreshed across all replicas. It returns false
+// if a refresh is already running, or if Redis can't tell.
+func (c *redisCache) StartRefresh(key string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	started, err := c.client.SetNX(ctx, c.prefix+"refresh:"+key, 1, redisRefreshLock).Result()
+	if err != nil {
+		log.Printf("Redis refresh lock for %s failed: %v", key, err)
+		return false
+	}
+	return started
+}
+
+// FinishRefresh clears the in-flight mark set by StartRefresh.
+func (c *redisCache) FinishRefresh(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	if err := c.client.Del(ctx, c.prefix+"refresh:"+key).Err(); err != nil {
+		log.Printf("Redis refresh unlock for %s failed: %v", key, err)
+	}
+}
+
+// Set stores value under key for ttl, letting Redis expire it afterwards.
+func (c *redisCache) Set(key string, value interface{}, ttl time.Duration) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(redisEntry{Value: value, Expires: time.Now().Add(ttl)}); err != nil {
+		log.Printf("Redis cache entry %s could not be encoded: %v", key, err)
+		return
+	}
+
+	expiry := ttl
+	if c.keepStale {
+		expiry *= 2
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := c.client.Set(ctx, c.entryKey(key), buf.Bytes(), expiry).Err(); err != nil {
+		log.Printf("Redis cache write of %s failed: %v", key, err)
+	}
+}
+
+// Flush removes every entry under the key prefix and returns how many there were.
+// Other applications' keys in the same Redis are left alone.
+func (c *redisCache) Flush() int {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	n := 0
+	iter := c.client.Scan(ctx, 0, c.entryKey("*"), 100).Iterator()
+	for iter.Next(ctx) {
+		if err := c.client.Del(ctx, iter.Val()).Err(); err != nil {
+			log.Printf("Redis cache flush of %s failed: %v", iter.Val(), err)
+			continue
+		}
+		n++
+	}
+	if err := iter.Err(); err != nil {
+		log.Printf("Redis cache flush stopped early: %v", err)
+	}
+	return n
+}
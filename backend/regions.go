@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// --- Multi-Region Overview ---
+
+// regionInstances lists the instances to report per region, from REGION_INSTANCES.
+var regionInstances = regionInstancesFromEnv()
+
+// regionInstancesFromEnv parses REGION_INSTANCES, a comma-separated list of
+// region:instance pairs such as "us-east-1:i-0abc123,eu-west-1:web-eu". Instances
+// may be INSTANCE_ALIASES names. Malformed entries are skipped.
+func regionInstancesFromEnv() map[string][]string {
+	regions := make(map[string][]string)
+	raw := os.Getenv("REGION_INSTANCES")
+	if raw == "" {
+		return regions
+	}
+	for _, entry := range strings.Split(raw, ",") {
+		region, instance, found := strings.Cut(entry, ":")
+		region, instance = strings.TrimSpace(region), resolveInstance(strings.TrimSpace(instance))
+		if !found || region == "" || !strings.HasPrefix(instance, "i-") {
+			log.Printf("Ignoring invalid REGION_INSTANCES entry %q, expected region:InstanceID", entry)
+			continue
+		}
+		regions[region] = append(regions[region], instance)
+	}
+	return regions
+}
+
+var (
+	regionalMu      sync.Mutex
+	regionalClients = make(map[string]CloudWatchAPI)
+)
+
+// cloudWatchForRegion returns a CloudWatch client for region, created on first use
+// and reused after that. The home region uses cwClient itself. Other regions get the
+// retry budget but not the circuit breaker, whose state feeds /readyz: one slow
+// region should show up in the overview, not take the whole service out of rotation.
+func cloudWatchForRegion(region string) CloudWatchAPI {
+	if region == awsConfig.Region {
+		return cwClient
+	}
+	regionalMu.Lock()
+	defer regionalMu.Unlock()
+
+	if client, ok := regionalClients[region]; ok {
+		return client
+	}
+	client := &breakerCloudWatch{next: cloudwatch.NewFromConfig(awsConfig, withoutSDKRetries, func(o *cloudwatch.Options) {
+		o.Region = region
+		if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})}
+	regionalClients[region] = client
+	return client
+}
+
+// multiRegionOverviewHandler reports the latest CPU and network values for every
+// REGION_INSTANCES instance, grouped by region. Regions are queried concurrently,
+// and a region that fails carries its own error instead of failing the response.
+// It accepts the usual ?range= and ?period=.
+func multiRegionOverviewHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if cwClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "AWS client not initialized")
+		return
+	}
+	if len(regionInstances) == 0 {
+		writeErrorJSON(w, http.StatusForbidden, codeForbidden, "Multi-region overview is disabled; set REGION_INSTANCES to enable it")
+		return
+	}
+
+	startTime, endTime, period, err := parseWindowAndPeriod(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	for region, instances := range regionInstances {
+		if len(instances)*len(overviewMetrics) > maxMetricQueries {
+			writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("REGION_INSTANCES lists too many instances for %s; at most %d per region", region, maxMetricQueries/len(overviewMetrics)))
+			return
+		}
+		if err := checkCardinality(len(instances)*len(overviewMetrics), startTime, endTime, period); err != nil {
+			writeErrorJSON(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	regions := make(map[string]interface{}, len(regionInstances))
+	for region, instances := range regionInstances {
+		wg.Add(1)
+		go func(region string, instances []string) {
+			defer wg.Done()
+			summary, err := regionOverview(r, region, instances, startTime, endTime, period)
+			if err != nil {
+				log.Printf("Error getting CloudWatch data for region %s: %v", region, err)
+				_, code := classifyUpstreamError(err)
+				summary = map[string]interface{}{"error": fmt.Sprintf("Error getting CloudWatch data: %v", err), "code": code}
+			}
+			mu.Lock()
+			regions[region] = summary
+			mu.Unlock()
+		}(region, instances)
+	}
+	wg.Wait()
+
+	writeJSON(w, r, map[string]interface{}{
+		"regions": regions,
+		"start":   startTime.UTC().Format(time.RFC3339),
+		"end":     endTime.UTC().Format(time.RFC3339),
+		"period":  period,
+	})
+}
+
+// overviewMetrics are the EC2 metrics in the multi-region overview, by the query ID
+// prefix used for them in ec2-usage.
+var overviewMetrics = []struct {
+	id, name, stat string
+}{
+	{"cpu", "CPUUtilization", "Average"},
+	{"netIn", "NetworkIn", "Sum"},
+	{"netOut", "NetworkOut", "Sum"},
+}
+
+// regionOverview fetches the overview metrics for instances in one region and
+// returns {"instances": {id: {cpu, netIn, netOut, ..._Timestamp}}}.
+func regionOverview(r *http.Request, region string, instances []string, start, end time.Time, period int32) (map[string]interface{}, error) {
+	type queryTarget struct{ instance, metric string }
+	targets := make(map[string]queryTarget)
+	queries := make([]types.MetricDataQuery, 0, len(instances)*len(overviewMetrics))
+	for i, instance := range instances {
+		for _, m := range overviewMetrics {
+			id := fmt.Sprintf("%s%d", m.id, i)
+			targets[id] = queryTarget{instance, m.id}
+			queries = append(queries, types.MetricDataQuery{
+				Id: aws.String(id),
+				MetricStat: &types.MetricStat{
+					Metric: &types.Metric{
+						Namespace:  aws.String("AWS/EC2"),
+						MetricName: aws.String(m.name),
+						Dimensions: []types.Dimension{{Name: aws.String("InstanceId"), Value: aws.String(instance)}},
+					},
+					Period: aws.Int32(period),
+					Stat:   aws.String(m.stat),
+				},
+				ReturnData: aws.Bool(true),
+			})
+		}
+	}
+
+	resp, err := getAllMetricDataFrom(r.Context(), cloudWatchForRegion(region), &cloudwatch.GetMetricDataInput{
+		StartTime:         &start,
+		EndTime:           &end,
+		MetricDataQueries: queries,
+		ScanBy:            types.ScanByTimestampDescending,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	byInstance := make(map[string]interface{}, len(instances))
+	for _, instance := range instances {
+		byInstance[publicInstanceID(instance)] = make(map[string]interface{})
+	}
+	for _, mdr := range resp.MetricDataResults {
+		target, ok := targets[aws.ToString(mdr.Id)]
+		if !ok {
+			continue
+		}
+		mdr.Id = aws.String(target.metric)
+		addLatestValues(byInstance[publicInstanceID(target.instance)].(map[string]interface{}), []types.MetricDataResult{mdr})
+	}
+	return map[string]interface{}{"instances": byInstance}, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/smithy-go"
+	"github.com/google/go-github/v58/github"
+)
+
+// --- Retry Budget ---
+
+// maxRetryDelay caps the exponential backoff between attempts.
+const maxRetryDelay = 5 * time.Second
+
+var (
+	retryMaxAttempts = intFromEnv("RETRY_MAX_ATTEMPTS", 3)                       // attempts per call, including the first
+	retryBaseDelay   = durationFromEnv("RETRY_BASE_DELAY", 200*time.Millisecond) // first backoff; doubles on each retry
+)
+
+// withRetryBudget runs call, retrying transient CloudWatch and GitHub failures (see
+// retryDelay) up to RETRY_MAX_ATTEMPTS times. All attempts share ctx's deadline as
+// one budget: once the time left is less than the next wait, it stops and returns
+// the last error instead of sleeping past the deadline into a 504 anyway.
+func withRetryBudget(ctx context.Context, call func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = call(); err == nil {
+			return nil
+		}
+		wait, retryable := retryDelay(err, attempt)
+		if !retryable || attempt >= retryMaxAttempts || ctx.Err() != nil {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			debugf("Not retrying after %v: %s backoff exceeds the remaining %s", err, wait, time.Until(deadline).Round(time.Millisecond))
+			return err
+		}
+		log.Printf("Retrying in %s after attempt %d failed: %v", wait, attempt, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// retryDelay reports whether err is worth retrying and how long to wait first:
+// GitHub's Retry-After for secondary rate limits, otherwise exponential backoff from
+// RETRY_BASE_DELAY for AWS throttling and server faults and GitHub 5xx responses.
+// Caller mistakes, auth failures and an open circuit breaker are not retried.
+func retryDelay(err error, attempt int) (time.Duration, bool) {
+	if errors.Is(err, errCircuitOpen) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return 0, false
+	}
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		if abuseErr.RetryAfter == nil {
+			return 0, false
+		}
+		return *abuseErr.RetryAfter, true
+	}
+
+	backoff := retryBaseDelay << (attempt - 1)
+	if backoff > maxRetryDelay || backoff <= 0 {
+		backoff = maxRetryDelay
+	}
+	switch githubErrorStatus(err) {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return backoff, true
+	}
+	if isThrottling(err) {
+		return backoff, true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorFault() == smithy.FaultServer {
+		return backoff, true
+	}
+	return 0, false
+}
+
+// withoutSDKRetries turns off the AWS SDK's own retries for a CloudWatch client, so
+// the only retries are withRetryBudget's and they stay within the request deadline.
+func withoutSDKRetries(o *cloudwatch.Options) {
+	o.RetryMaxAttempts = 1
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Metric Snapshots ---
+
+// defaultSnapshotMaxBytes is the size at which the snapshot file is rotated.
+const defaultSnapshotMaxBytes = 10 << 20
+
+// snapshotMu serializes writes and rotation of the snapshot file.
+var snapshotMu sync.Mutex
+
+// snapshotLine is one target's metrics in the snapshot file, one JSON object per line.
+type snapshotLine struct {
+	TakenAt time.Time              `json:"taken_at"`
+	Target  string                 `json:"target"`
+	Metrics map[string]interface{} `json:"metrics,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// snapshotConfig reads SNAPSHOT_FILE, SNAPSHOT_MAX_BYTES (e.g. "10MiB", default
+// 10 MiB) and SNAPSHOT_KEEP (rotated files to keep, default 10). An empty path
+// means snapshots are disabled.
+func snapshotConfig() (path string, maxBytes float64, keep int) {
+	maxBytes = defaultSnapshotMaxBytes
+	if raw := os.Getenv("SNAPSHOT_MAX_BYTES"); raw != "" {
+		if n, err := parseByteSize(raw); err == nil {
+			maxBytes = n
+		} else {
+			log.Printf("Invalid SNAPSHOT_MAX_BYTES '%s', using default of %d.", raw, defaultSnapshotMaxBytes)
+		}
+	}
+	return os.Getenv("SNAPSHOT_FILE"), maxBytes, intFromEnv("SNAPSHOT_KEEP", 10)
+}
+
+// snapshotHandler (POST, API key required) fetches the latest EC2 metrics of every
+// configured home-region instance (see ec2Targets) and appends one line per
+// instance to SNAPSHOT_FILE as newline-delimited JSON, for retention beyond
+// CloudWatch's or offline analysis. The file is rotated once it would grow past
+// SNAPSHOT_MAX_BYTES.
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeErrorJSON(w, http.StatusMethodNotAllowed, codeInvalidRequest, "Use POST to take a snapshot")
+		return
+	}
+	path, maxBytes, keep := snapshotConfig()
+	if path == "" {
+		writeErrorJSON(w, http.StatusForbidden, codeForbidden, "Snapshots are disabled; set SNAPSHOT_FILE to enable them")
+		return
+	}
+	if cwClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "AWS client not initialized")
+		return
+	}
+
+	var buf strings.Builder
+	taken := make([]string, 0)
+	errs := make(map[string]string)
+	now := time.Now().UTC()
+	for _, target := range ec2Targets() {
+		if target.Region != awsConfig.Region {
+			continue // other regions are only reachable through the overview
+		}
+		// Select the instance by its raw ID: with MASK_INSTANCE_IDS, Params has no
+		// instance for one without an alias, which would fetch the monitored instance.
+		query := url.Values{}
+		query.Set("instance", target.instance)
+		targetReq := r.Clone(r.Context())
+		targetReq.URL.RawQuery = query.Encode()
+
+		line := snapshotLine{TakenAt: now, Target: target.ID}
+		metrics, err := fetchEC2Usage(r.Context(), targetReq)
+		if err != nil {
+			log.Printf("Snapshot of %s failed: %v", target.ID, err)
+			line.Error = err.Error()
+			errs[target.ID] = err.Error()
+		} else {
+			line.Metrics = metrics
+		}
+		encoded, err := json.Marshal(line)
+		if err != nil {
+			log.Printf("Error encoding snapshot of %s: %v", target.ID, err)
+			errs[target.ID] = "Error encoding snapshot"
+			continue
+		}
+		buf.Write(encoded)
+		buf.WriteByte('\n')
+		taken = append(taken, target.ID)
+	}
+	if len(taken) == 0 {
+		writeErrorJSON(w, http.StatusServiceUnavailable, codeUnavailable, "No EC2 instances are configured to snapshot")
+		return
+	}
+
+	rotated, err := appendSnapshot(path, buf.String(), maxBytes, keep)
+	if err != nil {
+		log.Printf("Error writing snapshot to %s: %v", path, err)
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Error writing snapshot: %v", err))
+		return
+	}
+
+	result := map[string]interface{}{
+		"file":     filepath.Base(path),
+		"taken_at": now.Format(time.RFC3339),
+		"targets":  taken,
+	}
+	if rotated != "" {
+		result["rotated_to"] = filepath.Base(rotated)
+	}
+	writePartialJSON(w, r, result, errs)
+}
+
+// appendSnapshot appends data to path, first rotating the file to a timestamped
+// name if data would take it past maxBytes, and then deleting all but the keep
+// newest rotated files. It returns the rotated file's path, if any.
+func appendSnapshot(path, data string, maxBytes float64, keep int) (string, error) {
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	rotated := ""
+	if info, err := os.Stat(path); err == nil && info.Size() > 0 && float64(info.Size()+int64(len(data))) > maxBytes {
+		ext := filepath.Ext(path)
+		rotated = fmt.Sprintf("%s-%s%s", strings.TrimSuffix(path, ext), time.Now().UTC().Format("20060102T150405.000Z"), ext)
+		if err := os.Rename(path, rotated); err != nil {
+			return "", fmt.Errorf("rotating snapshot file: %w", err)
+		}
+		log.Printf("Rotated snapshot file to %s", rotated)
+		pruneSnapshots(path, keep)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(data); err != nil {
+		f.Close()
+		return "", err
+	}
+	return rotated, f.Close()
+}
+
+// rotatedSnapshots lists the rotated copies of path, oldest first; their timestamp
+// suffixes sort chronologically.
+func rotatedSnapshots(path string) []string {
+	ext := filepath.Ext(path)
+	matches, err := filepath.Glob(strings.TrimSuffix(path, ext) + "-*" + ext)
+	if err != nil {
+		return nil
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// pruneSnapshots deletes all but the keep newest rotated copies of path.
+func pruneSnapshots(path string, keep int) {
+	rotated := rotatedSnapshots(path)
+	for i := 0; i < len(rotated)-keep; i++ {
+		if err := os.Remove(rotated[i]); err != nil {
+			log.Printf("Error removing old snapshot file %s: %v", rotated[i], err)
+		}
+	}
+}
+
+// snapshotFile describes one snapshot file for /api/snapshots.
+type snapshotFile struct {
+	Name      string `json:"name"`
+	SizeBytes int64  `json:"size_bytes"`
+	Modified  string `json:"modified"`
+	Current   bool   `json:"current"` // the file new snapshots are appended to
+}
+
+// snapshotsHandler lists the snapshot file and its rotated copies, newest first.
+func snapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	path, _, _ := snapshotConfig()
+	if path == "" {
+		writeErrorJSON(w, http.StatusForbidden, codeForbidden, "Snapshots are disabled; set SNAPSHOT_FILE to enable them")
+		return
+	}
+
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+
+	files := make([]snapshotFile, 0)
+	paths := append([]string{path}, rotatedSnapshots(path)...)
+	for i, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			continue // the current file doesn't exist until the first snapshot
+		}
+		files = append(files, snapshotFile{
+			Name:      filepath.Base(p),
+			SizeBytes: info.Size(),
+			Modified:  info.ModTime().UTC().Format(time.RFC3339),
+			Current:   i == 0,
+		})
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Modified > files[j].Modified })
+	writeJSON(w, r, map[string]interface{}{"files": files})
+}
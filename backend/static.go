@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// --- Static Frontend ---
+
+// layeredFS is an http.FileSystem that looks a path up in each layer in order and
+// serves the first match, so an override directory can replace individual files
+// (logo, favicon, title) while everything else comes from the default frontend.
+type layeredFS []http.FileSystem
+
+// Open returns the file from the first layer that has it.
+func (l layeredFS) Open(name string) (http.File, error) {
+	var firstErr error
+	for _, layer := range l {
+		f, err := layer.Open(name)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, fs.ErrNotExist
+}
+
+// frontendFileSystem returns the frontend files, layered under BRANDING_DIR when it is set.
+func frontendFileSystem(frontendDir string) http.FileSystem {
+	brandingDir := os.Getenv("BRANDING_DIR")
+	if brandingDir == "" {
+		return http.Dir(frontendDir)
+	}
+	if info, err := os.Stat(brandingDir); err != nil || !info.IsDir() {
+		log.Printf("WARNING: BRANDING_DIR %q is not a readable directory, serving default frontend only", brandingDir)
+		return http.Dir(frontendDir)
+	}
+	log.Printf("Serving branding overrides from %s", brandingDir)
+	return layeredFS{http.Dir(brandingDir), http.Dir(frontendDir)}
+}
+
+// defaultStaticExtensions are the file types served from the frontend when
+// STATIC_EXTENSIONS is unset.
+const defaultStaticExtensions = "html,js,css,png,svg,ico,json,woff2"
+
+// staticExtensionsFromEnv reads STATIC_EXTENSIONS, a comma-separated list such as
+// "html,js,css", into a set of lower-case extensions with their leading dot.
+func staticExtensionsFromEnv() map[string]bool {
+	raw := os.Getenv("STATIC_EXTENSIONS")
+	if raw == "" {
+		raw = defaultStaticExtensions
+	}
+	allowed := make(map[string]bool)
+	for _, ext := range strings.Split(raw, ",") {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if ext != "" {
+			allowed["."+ext] = true
+		}
+	}
+	return allowed
+}
+
+// restrictStatic only lets next serve files with an allowed extension, plus directory
+// paths (which resolve to index.html). Dotfiles and anything inside a dot-directory,
+// such as .env or .git/config, are always a 404.
+func restrictStatic(allowed map[string]bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, segment := range strings.Split(r.URL.Path, "/") {
+			if strings.HasPrefix(segment, ".") {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		if !strings.HasSuffix(r.URL.Path, "/") && !allowed[strings.ToLower(path.Ext(r.URL.Path))] {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// frontendHandler serves the static frontend from dir (FRONTEND_DIR, default
+// ./frontend). When dir is missing, as in a backend-only deployment, it logs a
+// warning and falls back according to FRONTEND_FALLBACK: "landing" (the default)
+// serves a built-in page at / linking to the API, "message" answers every static
+// request with a JSON error explaining that the frontend is not installed.
+func frontendHandler(dir string) http.Handler {
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return restrictStatic(staticExtensionsFromEnv(), http.FileServer(frontendFileSystem(dir)))
+	}
+
+	fallback := os.Getenv("FRONTEND_FALLBACK")
+	if fallback != "message" {
+		fallback = "landing"
+	}
+	log.Printf("WARNING: frontend directory %q not found (set FRONTEND_DIR); serving the %s fallback instead of the UI", dir, fallback)
+	message := fmt.Sprintf("The CloudPulse frontend is not installed on this server (FRONTEND_DIR %q not found). The API is available under %s/api/.", dir, basePath)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fallback == "landing" && r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, landingPage(basePath))
+			return
+		}
+		writeErrorJSON(w, http.StatusNotFound, codeNotFound, message)
+	})
+}
+
+// landingPageLinks are the endpoints linked from the built-in landing page.
+var landingPageLinks = []string{"/api/openapi.json", "/api/config", "/api/ec2-usage", "/api/github-users", "/readyz"}
+
+// landingPage is the minimal HTML served at / when the frontend is missing.
+func landingPage(prefix string) string {
+	var links strings.Builder
+	for _, link := range landingPageLinks {
+		href := html.EscapeString(prefix + link)
+		fmt.Fprintf(&links, "<li><a href=\"%s\">%s</a></li>\n", href, href)
+	}
+	return `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>CloudPulse API</title></head>
+<body>
+<h1>CloudPulse API</h1>
+<p>The frontend is not installed on this server. The API is running; start with:</p>
+<ul>
+` + links.String() + `</ul>
+</body>
+</html>
+`
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// --- Long-Lived Streams ---
+
+// defaultStreamDrain is how long shutdown waits for streams to send their final
+// close event after being cancelled. Override with SHUTDOWN_STREAM_DRAIN.
+const defaultStreamDrain = 2 * time.Second
+
+// sseCloseEvent is the final Server-Sent Event of a stream the server ends, so
+// clients can tell a shutdown from a dropped connection and reconnect elsewhere.
+const sseCloseEvent = "event: close\ndata: {\"reason\":\"server shutting down\"}\n\n"
+
+// streamRegistry tracks the contexts of active streams so shutdown can end them;
+// otherwise server.Shutdown would wait on connections that never go idle.
+//
+// SSE handlers should call TrackSSE, select on the returned context, and call done
+// when they return; done sends the close event if the registry ended the stream.
+type streamRegistry struct {
+	mu      sync.Mutex
+	nextID  int
+	cancels map[int]context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// newStreamRegistry creates an empty registry.
+func newStreamRegistry() *streamRegistry {
+	return &streamRegistry{cancels: make(map[int]context.CancelFunc)}
+}
+
+// Track registers a stream derived from parent. done must be called when the
+// handler returns.
+func (s *streamRegistry) Track(parent context.Context) (ctx context.Context, done func()) {
+	ctx, cancel := context.WithCancel(parent)
+
+	s.mu.Lock()
+	id := s.nextID
+	s.nextID++
+	s.cancels[id] = cancel
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.cancels, id)
+			s.mu.Unlock()
+			cancel()
+			s.wg.Done()
+		})
+	}
+}
+
+// TrackSSE is Track for a Server-Sent Events handler writing to w. Its done sends
+// sseCloseEvent when the stream was ended by CloseAll rather than by the client
+// going away.
+func (s *streamRegistry) TrackSSE(w http.ResponseWriter, r *http.Request) (ctx context.Context, done func()) {
+	ctx, untrack := s.Track(r.Context())
+	return ctx, func() {
+		if ctx.Err() != nil && r.Context().Err() == nil {
+			fmt.Fprint(w, sseCloseEvent)
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		untrack()
+	}
+}
+
+// CloseAll cancels every tracked stream and waits up to drain for their handlers to return.
+func (s *streamRegistry) CloseAll(drain time.Duration) {
+	s.mu.Lock()
+	n := len(s.cancels)
+	for _, cancel := range s.cancels {
+		cancel()
+	}
+	s.mu.Unlock()
+	if n == 0 {
+		return
+	}
+
+	log.Printf("Closing %d active stream(s)...", n)
+	finished := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(drain):
+		log.Printf("Timed out after %s waiting for streams to close", drain)
+	}
+}
+
+// activeStreams is the registry shared by streaming handlers.
+var activeStreams = newStreamRegistry()
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStreamRegistryCloseAll(t *testing.T) {
+	s := newStreamRegistry()
+	ctx, done := s.Track(context.Background())
+	returned := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		done()
+		done() // a second call is a no-op
+		close(returned)
+	}()
+
+	s.CloseAll(time.Second)
+	select {
+	case <-returned:
+	default:
+		t.Fatal("CloseAll returned before the stream's done was called")
+	}
+	if len(s.cancels) != 0 {
+		t.Errorf("%d streams still tracked after done", len(s.cancels))
+	}
+}
+
+func TestStreamRegistryCloseAllDrainTimeout(t *testing.T) {
+	s := newStreamRegistry()
+	ctx, done := s.Track(context.Background())
+	defer done()
+
+	start := time.Now()
+	s.CloseAll(20 * time.Millisecond)
+	if ctx.Err() == nil {
+		t.Error("stream context not cancelled")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("CloseAll waited %s for a stream that never returns, want about the drain", elapsed)
+	}
+}
+
+func TestStreamRegistryTrackSSE(t *testing.T) {
+	s := newStreamRegistry()
+
+	// Ended by the registry: the client gets the close event.
+	w := httptest.NewRecorder()
+	ctx, done := s.TrackSSE(w, httptest.NewRequest(http.MethodGet, "/api/stream", nil))
+	go func() {
+		<-ctx.Done()
+		done()
+	}()
+	s.CloseAll(time.Second)
+	if w.Body.String() != sseCloseEvent {
+		t.Errorf("body = %q, want the close event", w.Body.String())
+	}
+	if !w.Flushed {
+		t.Error("close event not flushed")
+	}
+
+	// Ended by the client: nothing is written to the closed connection.
+	w = httptest.NewRecorder()
+	clientCtx, disconnect := context.WithCancel(context.Background())
+	_, done = s.TrackSSE(w, httptest.NewRequest(http.MethodGet, "/api/stream", nil).WithContext(clientCtx))
+	disconnect()
+	done()
+	if w.Body.Len() != 0 {
+		t.Errorf("body = %q after the client went away, want nothing", w.Body.String())
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// --- Monitored Targets ---
+
+// maxDiscoveredTargets caps how many resources ?discover=true lists per namespace.
+const maxDiscoveredTargets = 100
+
+// targetDimensions is the dimension that identifies one resource in each AWS
+// namespace, used by ?discover=true to find resources that publish metrics. EC2
+// instances are listed from configuration instead, where aliases and masking apply.
+var targetDimensions = map[string]string{
+	"AWS/RDS":            "DBInstanceIdentifier",
+	"AWS/Lambda":         "FunctionName",
+	"AWS/S3":             "BucketName",
+	"AWS/ElastiCache":    "CacheClusterId",
+	"AWS/DynamoDB":       "TableName",
+	"AWS/SQS":            "QueueName",
+	"AWS/ApplicationELB": "LoadBalancer",
+}
+
+// monitoredTarget is one thing CloudPulse can report on, with the endpoint and
+// query parameters that fetch it. A target that can't be selected through the API
+// (a masked instance without an alias) has Queryable false and no endpoint.
+type monitoredTarget struct {
+	ID        string            `json:"id"`
+	Region    string            `json:"region,omitempty"`
+	Endpoint  string            `json:"endpoint,omitempty"`
+	Params    map[string]string `json:"params,omitempty"`
+	Queryable bool              `json:"queryable"`
+	Sources   []string          `json:"sources"` // where CloudPulse learned about it, e.g. "monitored", "view:prod-cpu", "discovered"
+
+	instance string // the raw EC2 instance ID, which ID and Params may mask
+}
+
+// targetsHandler lists everything CloudPulse is configured to monitor, grouped by
+// service: the monitored instance, INSTANCE_ALIASES, REGION_INSTANCES and saved
+// views (ec2), ALLOWED_NAMESPACES (by service, "custom" for non-AWS namespaces)
+// and the GitHub repository. ?discover=true also lists the resources publishing
+// metrics in each allowed AWS namespace, at one ListMetrics call per namespace.
+func targetsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	discover := r.URL.Query().Get("discover") == "true"
+	if discover && cwClient == nil {
+		writeErrorJSON(w, http.StatusInternalServerError, codeInternalError, "AWS client not initialized")
+		return
+	}
+
+	targets := make(map[string][]*monitoredTarget)
+	targets["ec2"] = ec2Targets()
+
+	namespaces := make([]string, 0, len(allowedNamespaces))
+	for ns := range allowedNamespaces {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	errs := make(map[string]string)
+	for _, ns := range namespaces {
+		service := namespaceService(ns)
+		dimension, known := targetDimensions[ns]
+		if !discover || !known {
+			targets[service] = append(targets[service], &monitoredTarget{
+				ID:        ns,
+				Endpoint:  basePath + "/api/custom-metric",
+				Params:    map[string]string{"namespace": ns},
+				Queryable: true,
+				Sources:   []string{"allowed_namespaces"},
+			})
+			continue
+		}
+		found, err := discoverTargets(r, ns, dimension)
+		if err != nil {
+			log.Printf("Error discovering %s targets: %v", ns, err)
+			errs[ns] = fmt.Sprintf("Error listing CloudWatch metrics: %v", err)
+			continue
+		}
+		targets[service] = append(targets[service], found...)
+	}
+
+	if githubOwner != "" && githubRepo != "" {
+		targets["github"] = []*monitoredTarget{{
+			ID:        githubOwner + "/" + githubRepo,
+			Endpoint:  basePath + "/api/github-users",
+			Params:    map[string]string{},
+			Queryable: true,
+			Sources:   []string{"github_repo"},
+		}}
+	}
+
+	writePartialJSON(w, r, map[string]interface{}{"targets": targets}, errs)
+}
+
+// ec2Targets collects the configured EC2 instances, merging the sources of an
+// instance that is configured in several places.
+func ec2Targets() []*monitoredTarget {
+	byID := make(map[string]*monitoredTarget)
+	order := make([]string, 0)
+	add := func(id, region, source string) {
+		if t, ok := byID[id]; ok {
+			t.Sources = append(t.Sources, source)
+			return
+		}
+		t := &monitoredTarget{ID: publicInstanceID(id), Region: region, Endpoint: basePath + "/api/ec2-usage", Params: map[string]string{}, Queryable: true, Sources: []string{source}, instance: id}
+		if region != "" && region != awsConfig.Region {
+			t.Endpoint = basePath + "/api/multi-region-overview" // ec2-usage only reads the home region
+		} else if id != currentInstanceID() {
+			if param := instanceParam(id); param != "" {
+				t.Params["instance"] = param
+			} else {
+				// Without ?instance= the endpoint would report the monitored instance instead.
+				t.Endpoint, t.Params, t.Queryable = "", nil, false
+			}
+		}
+		byID[id] = t
+		order = append(order, id)
+	}
+
+	if id := currentInstanceID(); id != "" {
+		add(id, awsConfig.Region, "monitored")
+	}
+	aliased := make([]string, 0, len(instanceAliases))
+	for id := range instanceAliases {
+		aliased = append(aliased, id)
+	}
+	sort.Strings(aliased)
+	for _, id := range aliased {
+		add(id, awsConfig.Region, "instance_aliases")
+	}
+	regions := make([]string, 0, len(regionInstances))
+	for region := range regionInstances {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	for _, region := range regions {
+		for _, id := range regionInstances[region] {
+			add(id, region, "region_instances")
+		}
+	}
+	views := make([]string, 0, len(savedViews))
+	for name := range savedViews {
+		views = append(views, name)
+	}
+	sort.Strings(views)
+	for _, name := range views {
+		id := resolveInstance(savedViews[name]["instance"])
+		if id == "" {
+			id = currentInstanceID()
+		}
+		if id != "" && savedViews[name]["asg"] == "" {
+			add(id, awsConfig.Region, "view:"+name)
+		}
+	}
+
+	targets := make([]*monitoredTarget, 0, len(order))
+	for _, id := range order {
+		targets = append(targets, byID[id])
+	}
+	return targets
+}
+
+// instanceParam is the ?instance= value that selects id: its alias when it has one,
+// otherwise the ID itself, or "" when MASK_INSTANCE_IDS hides it.
+func instanceParam(id string) string {
+	if alias, ok := instanceAliases[id]; ok {
+		return alias
+	}
+	if maskInstanceIDs {
+		return ""
+	}
+	return id
+}
+
+// namespaceService groups a namespace by service: "AWS/RDS" is "rds", and
+// namespaces outside AWS/ are "custom".
+func namespaceService(namespace string) string {
+	if service, ok := strings.CutPrefix(namespace, "AWS/"); ok {
+		return strings.ToLower(service)
+	}
+	return "custom"
+}
+
+// discoverTargets lists the distinct values of dimension among the metrics
+// published in namespace, each as a custom-metric target.
+func discoverTargets(r *http.Request, namespace, dimension string) ([]*monitoredTarget, error) {
+	seen := make(map[string]bool)
+	targets := make([]*monitoredTarget, 0)
+	input := &cloudwatch.ListMetricsInput{
+		Namespace:  aws.String(namespace),
+		Dimensions: []types.DimensionFilter{{Name: aws.String(dimension)}},
+	}
+	for len(targets) < maxDiscoveredTargets {
+		resp, err := cwClient.ListMetrics(r.Context(), input)
+		if err != nil {
+			return nil, err
+		}
+		for _, metric := range resp.Metrics {
+			for _, dim := range metric.Dimensions {
+				value := aws.ToString(dim.Value)
+				if aws.ToString(dim.Name) != dimension || seen[value] || len(targets) == maxDiscoveredTargets {
+					continue
+				}
+				seen[value] = true
+				targets = append(targets, &monitoredTarget{
+					ID:        value,
+					Endpoint:  basePath + "/api/custom-metric",
+					Params:    map[string]string{"namespace": namespace, "dim": dimension + ":" + value},
+					Queryable: true,
+					Sources:   []string{"discovered"},
+				})
+			}
+		}
+		if resp.NextToken == nil {
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].ID < targets[j].ID })
+	return targets, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// --- TLS ---
+
+// tlsVersions maps TLS_MIN_VERSION values to crypto/tls constants. Versions below 1.2
+// are deliberately not offered.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsConfigFromEnv builds the server's tls.Config from TLS_MIN_VERSION (default 1.2)
+// and TLS_CIPHER_SUITES (comma-separated Go cipher suite names, e.g.
+// TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256). Only suites Go considers secure are
+// accepted. Without TLS_CIPHER_SUITES, Go's default secure suites are used.
+// Cipher suites only apply to TLS 1.2; TLS 1.3 suites are not configurable in Go.
+func tlsConfigFromEnv() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if raw := os.Getenv("TLS_MIN_VERSION"); raw != "" {
+		version, ok := tlsVersions[raw]
+		if !ok {
+			return nil, fmt.Errorf("invalid TLS_MIN_VERSION '%s', expected 1.2 or 1.3", raw)
+		}
+		cfg.MinVersion = version
+	}
+
+	if raw := os.Getenv("TLS_CIPHER_SUITES"); raw != "" {
+		secure := make(map[string]uint16)
+		for _, suite := range tls.CipherSuites() {
+			secure[suite.Name] = suite.ID
+		}
+		for _, name := range strings.Split(raw, ",") {
+			name = strings.TrimSpace(name)
+			id, ok := secure[name]
+			if !ok {
+				return nil, fmt.Errorf("unsupported or insecure cipher suite '%s' in TLS_CIPHER_SUITES", name)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+	return cfg, nil
+}
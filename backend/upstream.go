@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/smithy-go"
+	"github.com/google/go-github/v58/github"
+)
+
+// --- Upstream Error Classification ---
+
+// Machine-readable error codes sent alongside the message in error bodies.
+const (
+	codeInvalidRequest = "invalid_request"
+	codeUnauthorized   = "unauthorized"
+	codeForbidden      = "forbidden"
+	codeNotFound       = "not_found"
+	codeRateLimited    = "rate_limited"
+	codeTimeout        = "timeout"
+	codeUnavailable    = "unavailable"
+	codeUpstreamError  = "upstream_error"
+	codeInternalError  = "internal_error"
+)
+
+// AWS error codes by the HTTP status CloudPulse reports them as. Throttling and
+// access-denied codes are covered by isThrottling and isAccessDenied.
+var (
+	awsAuthErrorCodes = map[string]bool{
+		"UnrecognizedClientException": true,
+		"InvalidClientTokenId":        true,
+		"ExpiredToken":                true,
+		"ExpiredTokenException":       true,
+		"AuthFailure":                 true,
+		"SignatureDoesNotMatch":       true,
+		"InvalidSignatureException":   true,
+		"MissingAuthenticationToken":  true,
+	}
+	awsNotFoundErrorCodes = map[string]bool{
+		"ResourceNotFound":           true,
+		"ResourceNotFoundException":  true,
+		"InvalidInstanceID.NotFound": true,
+	}
+	awsValidationErrorCodes = map[string]bool{
+		"InvalidParameterValue":          true,
+		"InvalidParameterValueException": true,
+		"InvalidParameterCombination":    true,
+		"MissingParameter":               true,
+		"ValidationError":                true,
+		"ValidationException":            true,
+		"InvalidNextToken":               true,
+		"InvalidInstanceID.Malformed":    true,
+	}
+)
+
+// classifyUpstreamError maps a failed AWS or GitHub call to the HTTP status and
+// error code it should be reported with. Anything unrecognised is a 500.
+func classifyUpstreamError(err error) (int, string) {
+	if errors.Is(err, errCircuitOpen) {
+		return http.StatusServiceUnavailable, codeUnavailable
+	}
+	if isTimeout(err) {
+		return http.StatusGatewayTimeout, codeTimeout
+	}
+
+	var abuseErr *github.AbuseRateLimitError
+	var rateErr *github.RateLimitError
+	if errors.As(err, &abuseErr) || errors.As(err, &rateErr) {
+		return http.StatusTooManyRequests, codeRateLimited
+	}
+	switch githubErrorStatus(err) {
+	case http.StatusUnauthorized:
+		return http.StatusUnauthorized, codeUnauthorized
+	case http.StatusForbidden:
+		return http.StatusForbidden, codeForbidden
+	case http.StatusNotFound:
+		return http.StatusNotFound, codeNotFound
+	case http.StatusUnprocessableEntity:
+		return http.StatusBadRequest, codeInvalidRequest
+	}
+
+	if isThrottling(err) {
+		return http.StatusTooManyRequests, codeRateLimited
+	}
+	if isAccessDenied(err) {
+		return http.StatusForbidden, codeForbidden
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch code := apiErr.ErrorCode(); {
+		case awsAuthErrorCodes[code]:
+			return http.StatusUnauthorized, codeUnauthorized
+		case awsNotFoundErrorCodes[code]:
+			return http.StatusNotFound, codeNotFound
+		case awsValidationErrorCodes[code]:
+			return http.StatusBadRequest, codeInvalidRequest
+		}
+	}
+	return http.StatusInternalServerError, codeUpstreamError
+}
+
+// codeForStatus is the error code for a status CloudPulse chose itself, e.g. a 400
+// from parameter validation.
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return codeInvalidRequest
+	case http.StatusUnauthorized:
+		return codeUnauthorized
+	case http.StatusForbidden:
+		return codeForbidden
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusTooManyRequests:
+		return codeRateLimited
+	case http.StatusServiceUnavailable:
+		return codeUnavailable
+	case http.StatusGatewayTimeout:
+		return codeTimeout
+	}
+	return codeInternalError
+}
+
+// upstreamAPIError wraps a failed upstream call as an apiError with its classified
+// status and code, for functions that return errors to writeAPIError.
+func upstreamAPIError(msg string, err error) *apiError {
+	status, code := classifyUpstreamError(err)
+	return &apiError{Status: status, Code: code, Message: fmt.Sprintf("%s: %v", msg, err), Err: err}
+}
+
+// writeUpstreamError reports a failed AWS or GitHub call as {"error", "code"} with
+// the status from classifyUpstreamError.
+func writeUpstreamError(w http.ResponseWriter, msg string, err error) {
+	status, code := classifyUpstreamError(err)
+	writeErrorJSON(w, status, code, fmt.Sprintf("%s: %v", msg, err))
+}
+
+// githubErrorField is one per-field error from a GitHub error response.
+type githubErrorField struct {
+	Resource string `json:"resource,omitempty"`
+	Field    string `json:"field,omitempty"`
+	Code     string `json:"code,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+// githubErrorBody is the error body for a GitHub error response: the usual
+// {"error", "code"} plus what GitHub said, so the frontend can tell e.g. "Not Found"
+// from "Bad credentials".
+type githubErrorBody struct {
+	Error            string             `json:"error"`
+	Code             string             `json:"code"`
+	Status           int                `json:"status,omitempty"` // GitHub's HTTP status, which may differ from ours
+	Message          string             `json:"message"`
+	DocumentationURL string             `json:"documentation_url,omitempty"`
+	Errors           []githubErrorField `json:"errors,omitempty"`
+}
+
+// writeGitHubErrorResponse reports a go-github ErrorResponse with its status,
+// message, documentation URL and field errors, using the status from
+// classifyUpstreamError.
+func writeGitHubErrorResponse(w http.ResponseWriter, msg string, ghErr *github.ErrorResponse) {
+	status, code := classifyUpstreamError(ghErr)
+	body := githubErrorBody{
+		Error:            fmt.Sprintf("%s: %v", msg, ghErr),
+		Code:             code,
+		Status:           githubErrorStatus(ghErr),
+		Message:          ghErr.Message,
+		DocumentationURL: ghErr.DocumentationURL,
+	}
+	for _, e := range ghErr.Errors {
+		body.Errors = append(body.Errors, githubErrorField{Resource: e.Resource, Field: e.Field, Code: e.Code, Message: e.Message})
+	}
+	writeErrorBody(w, status, code, body.Error, body)
+}
+
+// writeErrorJSON writes a structured {"error", "code"} body.
+func writeErrorJSON(w http.ResponseWriter, status int, code, message string) {
+	writeErrorBody(w, status, code, message, map[string]string{"error": message, "code": code})
+}
+
+// writeErrorBody writes body as an error response. For a request that wants the
+// envelope (see markEnvelope), it writes an envelope with null data and the code and
+// message in errors instead.
+func writeErrorBody(w http.ResponseWriter, status int, code, message string, body interface{}) {
+	if ew, ok := w.(*envelopeWriter); ok {
+		body = envelope(w, ew.r, nil, []responseError{{Code: code, Message: message}})
+	}
+	encoded, _ := json.Marshal(body)
+	http.Error(w, string(encoded), status)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+)
+
+// --- Saved Views ---
+
+// savedViews maps a view name to the ec2-usage query parameters it stands for,
+// loaded from the JSON file named by VIEWS_FILE, e.g.
+//
+//	{"prod-cpu": {"instance": "i-0abc123", "range": "24h", "mode": "series", "stat": "Average,Maximum"}}
+var savedViews map[string]map[string]string
+
+// loadSavedViews reads VIEWS_FILE. Without it there are simply no saved views.
+func loadSavedViews() error {
+	path := os.Getenv("VIEWS_FILE")
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read VIEWS_FILE: %w", err)
+	}
+	var views map[string]map[string]string
+	if err := json.Unmarshal(data, &views); err != nil {
+		return fmt.Errorf("failed to parse VIEWS_FILE %s: %w", path, err)
+	}
+	for name, spec := range views {
+		// ?debug=raw needs the API key; a view must not be a way around it.
+		if _, ok := spec["debug"]; ok {
+			return fmt.Errorf("invalid view '%s' in VIEWS_FILE %s: 'debug' is not allowed in saved views", name, path)
+		}
+	}
+	savedViews = views
+	log.Printf("Loaded %d saved views from %s", len(views), path)
+	return nil
+}
+
+// viewOutputParams are the caller's parameters that carry over onto a saved view's
+// query: they change how the result is written, not which metrics are fetched.
+var viewOutputParams = []string{"pretty", "fields", "envelope", "format", "shape", "group"}
+
+// viewHandler serves a saved view (?name=prod-cpu) by running its query, plus the
+// caller's viewOutputParams, through ec2UsageHandler, so it gets the same validation
+// and metric cache. Without ?name=, it lists the available view names.
+func viewHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		names := make([]string, 0, len(savedViews))
+		for n := range savedViews {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		writeJSON(w, r, map[string]interface{}{"views": names})
+		return
+	}
+
+	spec, ok := savedViews[name]
+	if !ok {
+		writeErrorJSON(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("Unknown view '%s'", name))
+		return
+	}
+
+	query := url.Values{}
+	for k, v := range spec {
+		query.Set(k, v)
+	}
+	for _, param := range viewOutputParams {
+		if v := r.URL.Query().Get(param); v != "" {
+			query.Set(param, v)
+		}
+	}
+	viewReq := r.Clone(r.Context())
+	viewReq.URL.RawQuery = query.Encode()
+	ec2UsageHandler(w, viewReq)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// useSavedViews replaces savedViews for the rest of the test.
+func useSavedViews(t *testing.T, views map[string]map[string]string) {
+	t.Helper()
+	prev := savedViews
+	savedViews = views
+	t.Cleanup(func() { savedViews = prev })
+}
+
+// getView calls viewHandler through markEnvelope, as the router does.
+func getView(t *testing.T, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	markEnvelope(http.HandlerFunc(viewHandler)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/view"+query, nil))
+	return w
+}
+
+func TestViewUnknownName(t *testing.T) {
+	useFakeCloudWatch(t, &fakeCloudWatch{})
+	useSavedViews(t, map[string]map[string]string{"prod-cpu": {"range": "24h"}})
+
+	w := getView(t, "?name=missing")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", w.Code)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response is not JSON: %v\n%s", err, w.Body.String())
+	}
+	if body["code"] != codeNotFound {
+		t.Errorf("code = %v, want %s", body["code"], codeNotFound)
+	}
+	if body["error"] != "Unknown view 'missing'" {
+		t.Errorf("error = %v", body["error"])
+	}
+}
+
+func TestViewKnownName(t *testing.T) {
+	useFakeCloudWatch(t, &fakeCloudWatch{results: []types.MetricDataResult{
+		metricResult("cpu", 42.5), metricResult("memUsed"), metricResult("netIn"), metricResult("netOut"),
+	}})
+	useSavedViews(t, map[string]map[string]string{"prod-cpu": {"range": "1h"}})
+
+	w := getView(t, "?name=prod-cpu")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200; body %s", w.Code, w.Body.String())
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response is not JSON: %v\n%s", err, w.Body.String())
+	}
+	if body["cpu"] != 42.5 {
+		t.Errorf("cpu = %v, want 42.5", body["cpu"])
+	}
+
+	// The caller's output parameters apply on top of the view's query.
+	w = getView(t, "?name=prod-cpu&envelope=true")
+	var enveloped responseEnvelope
+	if err := json.Unmarshal(w.Body.Bytes(), &enveloped); err != nil {
+		t.Fatalf("response is not JSON: %v\n%s", err, w.Body.String())
+	}
+	if data, _ := enveloped.Data.(map[string]interface{}); data["cpu"] != 42.5 {
+		t.Errorf("envelope data = %v, want cpu 42.5", enveloped.Data)
+	}
+
+	// ...and go through the same validation as /api/ec2-usage.
+	w = getView(t, "?name=prod-cpu&format=csv")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("format=csv without mode=series: status = %d, want 400; body %s", w.Code, w.Body.String())
+	}
+}
+
+func TestLoadSavedViewsRejectsDebug(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "views.json")
+	if err := os.WriteFile(path, []byte(`{"raw": {"debug": "raw"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VIEWS_FILE", path)
+	useSavedViews(t, nil)
+
+	err := loadSavedViews()
+	if err == nil || !strings.Contains(err.Error(), "'debug' is not allowed") {
+		t.Errorf("loadSavedViews() = %v, want an error about 'debug'", err)
+	}
+	if savedViews != nil {
+		t.Errorf("savedViews = %v, want none loaded", savedViews)
+	}
+}
@@ -58,8 +58,9 @@ function setText(elementId, text, defaultValue = 'N/A') {
  */
 async function fetchData(url) {
     try {
-        // Relative paths work when frontend is served by the Go backend.
-        // For local file:// testing, you might need full URLs and CORS handling.
+        // Relative paths work when frontend is served by the Go backend, including
+        // under a BASE_PATH subpath (e.g. /cloudpulse/api/...), since they resolve
+        // against the page URL. For local file:// testing, you might need full URLs and CORS handling.
         const response = await fetch(url);
         if (!response.ok) {
             let errorMsg = `HTTP error! Status: ${response.status}`;
@@ -85,7 +86,7 @@ async function fetchData(url) {
  */
 async function fetchEC2Usage() {
     try {
-        const data = await fetchData('/api/ec2-usage');
+        const data = await fetchData('api/ec2-usage');
         console.log("EC2 Data Received:", data);
 
         setText('ec2-instance-id', data.InstanceID, 'N/A');
@@ -127,7 +128,7 @@ async function fetchEC2Usage() {
  */
 async function fetchFreeTierUsage() {
     try {
-        const data = await fetchData('/api/free-tier-usage');
+        const data = await fetchData('api/free-tier-usage');
         setText('ft-ec2-hours-used', data.ec2HoursUsed !== undefined ? data.ec2HoursUsed : 'N/A');
         setText('ft-ec2-hours-remaining', data.ec2HoursRemaining !== undefined ? data.ec2HoursRemaining : 'N/A');
         setText('ft-data-transfer-out-used', data.dataTransferOutUsed !== undefined ? `${data.dataTransferOutUsed} GB` : 'N/A');
@@ -163,7 +164,7 @@ function updateFreeTierInfo() {
 async function fetchGitHubUsers() {
     const usersListElement = document.getElementById('github-users-list');
     try {
-        const users = await fetchData('/api/github-users');
+        const users = await fetchData('api/github-users');
         console.log("GitHub Users Received:", users);
 
         // For GitHub repo name - backend would ideally supply this.